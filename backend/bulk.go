@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// bulkOperation is one entry of a POST /products/bulk request. Version
+// makes updates and deletes conditional like If-Match does; it is optional
+// unless REQUIRE_IF_MATCH is set.
+type bulkOperation struct {
+	Op      string  `json:"op"`
+	ID      uint    `json:"id"`
+	Code    *string `json:"code"`
+	Price   *uint   `json:"price"`
+	Version uint    `json:"version"`
+}
+
+// bulkResult is the outcome of one bulkOperation, in request order.
+type bulkResult struct {
+	Index  int       `json:"index"`
+	Op     string    `json:"op"`
+	Status int       `json:"status"`
+	Data   *Product  `json:"data,omitempty"`
+	Error  *APIError `json:"error,omitempty"`
+}
+
+// errBulkRollback aborts the transaction of an atomic batch.
+var errBulkRollback = errors.New("bulk operation failed")
+
+// fail sets the result's error and the status registered for its code.
+func (res *bulkResult) fail(ctx context.Context, code string, details interface{}) {
+	apiErr := NewAPIError(ctx, code, details)
+	res.Status = lookupErrorCode(code).Status
+	res.Data = nil
+	res.Error = &apiErr
+}
+
+// runBulkOperation validates and applies a single operation.
+func runBulkOperation(ctx context.Context, repo ProductRepository, index int, op bulkOperation, requireVersion bool) bulkResult {
+	res := bulkResult{Index: index, Op: op.Op}
+
+	if requireVersion && op.Version == 0 && (op.Op == "update" || op.Op == "delete") {
+		res.fail(ctx, CodePreconditionRequired, "version is required")
+		return res
+	}
+
+	var (
+		product Product
+		err     error
+	)
+	switch op.Op {
+	case "create":
+		var code string
+		var price uint
+		if op.Code != nil {
+			code = *op.Code
+		}
+		if op.Price != nil {
+			price = *op.Price
+		}
+		if fields := validateProduct(code, price); len(fields) > 0 {
+			res.fail(ctx, CodeValidationFailed, fields)
+			return res
+		}
+		product, err = repo.Create(ctx, *op.Code, *op.Price)
+		res.Status = http.StatusCreated
+	case "update":
+		if op.ID == 0 {
+			res.fail(ctx, CodeInvalidID, nil)
+			return res
+		}
+		if op.Code == nil && op.Price == nil {
+			res.fail(ctx, CodeInvalidRequest, "update requires code or price")
+			return res
+		}
+		if fields := validateProductChanges(ProductChanges{Code: op.Code, Price: op.Price}); len(fields) > 0 {
+			res.fail(ctx, CodeValidationFailed, fields)
+			return res
+		}
+		product, err = repo.Update(ctx, op.ID, ProductChanges{Code: op.Code, Price: op.Price}, op.Version)
+		res.Status = http.StatusOK
+	case "delete":
+		if op.ID == 0 {
+			res.fail(ctx, CodeInvalidID, nil)
+			return res
+		}
+		err = repo.Delete(ctx, op.ID, op.Version)
+		res.Status = http.StatusOK
+	default:
+		res.fail(ctx, CodeInvalidRequest, map[string]interface{}{
+			"op":      op.Op,
+			"allowed": []string{"create", "update", "delete"},
+		})
+		return res
+	}
+
+	switch {
+	case err == nil:
+		if op.Op != "delete" {
+			res.Data = &product
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		res.fail(ctx, CodeProductNotFound, map[string]interface{}{"id": op.ID})
+	case errors.Is(err, ErrVersionConflict):
+		res.fail(ctx, CodePreconditionFailed, map[string]interface{}{"id": op.ID})
+	case errors.Is(err, ErrCodeConflict):
+		res.fail(ctx, CodeProductCodeConflict, map[string]interface{}{"code": *op.Code})
+	default:
+		res.fail(ctx, CodeInternalError, internalErrorDetails(ctx, err))
+	}
+	return res
+}
+
+// bulkProducts serves POST /products/bulk. The body is a JSON array of
+// operations, e.g. `[{"op":"create","code":"A","price":1},
+// {"op":"update","id":3,"price":2},{"op":"delete","id":4}]`.
+//
+// By default every operation is attempted and the response lists each
+// outcome. With `?atomic=true` the batch runs in one transaction and
+// stops at the first failure; the whole batch is then rolled back and
+// answered with 422 BULK_ROLLED_BACK, the other operations reported as
+// 424 Failed Dependency.
+func bulkProducts(c *gin.Context, repo ProductRepository, maxBulkSize int, requireIfMatch bool) {
+	var ops []bulkOperation
+	if err := c.ShouldBindJSON(&ops); err != nil {
+		respondErrorCode(c, CodeInvalidRequest, err.Error())
+		return
+	}
+	if len(ops) == 0 {
+		respondErrorCode(c, CodeInvalidRequest, "at least one operation is required")
+		return
+	}
+	if len(ops) > maxBulkSize {
+		respondErrorCode(c, CodeBulkTooLarge, map[string]interface{}{"requested": len(ops), "max_bulk_size": maxBulkSize})
+		return
+	}
+
+	ctx := c.Request.Context()
+	atomic := c.Query("atomic") == "true"
+	results := make([]bulkResult, 0, len(ops))
+
+	if !atomic {
+		for i, op := range ops {
+			results = append(results, runBulkOperation(ctx, repo, i, op, requireIfMatch))
+		}
+	} else {
+		failed := -1
+		err := repo.Transaction(ctx, func(tx ProductRepository) error {
+			for i, op := range ops {
+				res := runBulkOperation(ctx, tx, i, op, requireIfMatch)
+				results = append(results, res)
+				if res.Error != nil {
+					failed = i
+					return errBulkRollback
+				}
+			}
+			return nil
+		})
+		if failed >= 0 {
+			for i := range results {
+				if i != failed {
+					results[i].fail(ctx, CodeBulkOperationNotApplied, nil)
+				}
+			}
+			for i := len(results); i < len(ops); i++ {
+				res := bulkResult{Index: i, Op: ops[i].Op}
+				res.fail(ctx, CodeBulkOperationNotApplied, nil)
+				results = append(results, res)
+			}
+			respondErrorCode(c, CodeBulkRolledBack, map[string]interface{}{
+				"failed_index": failed,
+				"results":      results,
+			})
+			return
+		}
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+	}
+
+	succeeded := 0
+	for _, res := range results {
+		if res.Error == nil {
+			succeeded++
+		}
+	}
+	respondSuccess(c, http.StatusOK, results, map[string]interface{}{
+		"total":     len(results),
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+		"atomic":    atomic,
+	})
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 func main() {
 	outDir := flag.String("out", "frontend/src", "output directory for generated files")
+	srcDir := flag.String("src", "backend", "directory holding the backend Go sources")
 	flag.Parse()
 
 	if err := os.MkdirAll(*outDir, 0o755); err != nil {
@@ -21,7 +25,7 @@ func main() {
 	}
 
 	// Read backend/errors.go
-	errsSrc, err := ioutil.ReadFile("backend/errors.go")
+	errsSrc, err := ioutil.ReadFile(filepath.Join(*srcDir, "errors.go"))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read backend/errors.go: %v\n", err)
 		os.Exit(2)
@@ -39,10 +43,20 @@ func main() {
 		}
 	}
 
-	// Extract ErrorMessages map entries by scanning for CodeName: "message"
+	// Extract ErrorMessages map entries by scanning for CodeName: "message",
+	// or registry entries `CodeName: {Status: ..., Message: "message", ...}`
 	messages := map[string]string{}
+	retryable := map[string]bool{}
 	for _, c := range codes {
 		name := c[0]
+		reEntry := regexp.MustCompile(fmt.Sprintf(`%s\s*:\s*\{([^}\n]*)\}`, regexp.QuoteMeta(name)))
+		if m := reEntry.FindStringSubmatch(errs); len(m) >= 2 {
+			if mm := regexp.MustCompile(`Message:\s*"([^"]+)"`).FindStringSubmatch(m[1]); mm != nil {
+				messages[name] = mm[1]
+			}
+			retryable[name] = regexp.MustCompile(`Retryable:\s*true`).MatchString(m[1])
+			continue
+		}
 		// try plain `CodeName: "msg"`
 		rePlain := regexp.MustCompile(fmt.Sprintf(`%s\s*:\s*"([^"]+)"`, regexp.QuoteMeta(name)))
 		if m := rePlain.FindStringSubmatch(errs); len(m) >= 2 {
@@ -74,11 +88,48 @@ func main() {
 		}
 	}
 	b.WriteString("};\n\n")
-	b.WriteString("export default {\n")
+
+	// Codes whose requests may succeed when sent again, and their docs
+	b.WriteString("export const RetryableErrorCodes: ReadonlySet<string> = new Set([\n")
+	for _, c := range codes {
+		if retryable[c[0]] {
+			b.WriteString(fmt.Sprintf("  %s,\n", c[0]))
+		}
+	}
+	b.WriteString("]);\n\n")
+	if m := regexp.MustCompile(`errorDocsURL\s*=\s*"([^"]+)"`).FindStringSubmatch(errs); m != nil {
+		b.WriteString(fmt.Sprintf("export const errorDocURL = (code: string): string => `%s#${code.toLowerCase()}`;\n\n", m[1]))
+	}
+
+	// Translations from backend/locales/<language>.json, keyed like ErrorMessages
+	catalogs, err := readCatalogs(filepath.Join(*srcDir, "locales"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read backend/locales: %v\n", err)
+		os.Exit(2)
+	}
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	b.WriteString("export const LocalizedErrorMessages: Record<string, Record<string, string>> = {\n")
+	b.WriteString("  \"en\": ErrorMessages,\n")
+	for _, lang := range langs {
+		b.WriteString(fmt.Sprintf("  %s: {\n", strconv.Quote(lang)))
+		for _, c := range codes {
+			if msg, ok := catalogs[lang][c[1]]; ok {
+				b.WriteString(fmt.Sprintf("    [%s]: %s,\n", c[0], strconv.Quote(msg)))
+			}
+		}
+		b.WriteString("  },\n")
+	}
+	b.WriteString("};\n\n")
+	b.WriteString(errorMessageTS)
+	b.WriteString("\nexport default {\n")
 	for _, c := range codes {
 		b.WriteString(fmt.Sprintf("  %s,\n", c[0]))
 	}
-	b.WriteString("  ErrorMessages,\n};\n")
+	b.WriteString("  ErrorMessages,\n  RetryableErrorCodes,\n  LocalizedErrorMessages,\n  errorMessage,\n};\n")
 
 	err = ioutil.WriteFile(filepath.Join(*outDir, "errorCodes.ts"), []byte(b.String()), 0o644)
 	if err != nil {
@@ -87,7 +138,7 @@ func main() {
 	}
 
 	// Read backend/model.go to infer Product fields
-	modelSrc, err := ioutil.ReadFile("backend/model.go")
+	modelSrc, err := ioutil.ReadFile(filepath.Join(*srcDir, "model.go"))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read backend/model.go: %v\n", err)
 		os.Exit(2)
@@ -98,7 +149,7 @@ func main() {
 	var t strings.Builder
 	t.WriteString("// GENERATED: API response types for frontend\n\n")
 	t.WriteString("export interface APIError {\n  code: string;\n  message: string;\n  details?: any;\n}\n\n")
-	t.WriteString("export interface ErrorEnvelope {\n  success: false;\n  status: number;\n  error: APIError;\n}\n\n")
+	t.WriteString("export interface ErrorEnvelope {\n  success: false;\n  status: number;\n  request_id: string;\n  error: APIError;\n}\n\n")
 	t.WriteString("export interface SuccessEnvelope<T> {\n  success: true;\n  status: number;\n  data: T;\n  meta?: Record<string, any>;\n}\n\n")
 	// Product
 	t.WriteString("export interface Product {\n")
@@ -116,9 +167,171 @@ func main() {
 		os.Exit(2)
 	}
 
+	// Read backend/validation.go for the product field rules
+	validationSrc, err := ioutil.ReadFile(filepath.Join(*srcDir, "validation.go"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read backend/validation.go: %v\n", err)
+		os.Exit(2)
+	}
+	err = ioutil.WriteFile(filepath.Join(*outDir, "validationRules.ts"), []byte(generateValidationRules(string(validationSrc))), 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write validationRules.ts: %v\n", err)
+		os.Exit(2)
+	}
+
 	fmt.Fprintf(os.Stderr, "generated files in %s\n", *outDir)
 }
 
+// readCatalogs reads the translated error messages of every
+// `<language>.json` file in dir, keyed by lowercase language tag.
+func readCatalogs(dir string) (map[string]map[string]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	catalogs := map[string]map[string]string{}
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+		catalogs[strings.ToLower(strings.TrimSuffix(filepath.Base(p), ".json"))] = messages
+	}
+	return catalogs, nil
+}
+
+// errorMessageTS picks a message with the backend's fallback chain (see
+// languageChain in backend/i18n.go): each preferred language, then its
+// primary subtag, then English.
+const errorMessageTS = `export function errorMessage(
+  code: string,
+  languages: readonly string[] = typeof navigator !== "undefined" ? navigator.languages : [],
+): string {
+  for (const language of languages) {
+    const tag = language.toLowerCase();
+    for (const candidate of [tag, tag.split("-")[0]]) {
+      const msg = LocalizedErrorMessages[candidate]?.[code];
+      if (msg) return msg;
+    }
+  }
+  return ErrorMessages[code] ?? code;
+}
+`
+
+// generateValidationRules emits validationRules.ts from the binding tags
+// of `type productPayload struct` and the productCodePattern constant.
+// validateProduct mirrors the backend's rules and messages.
+func generateValidationRules(src string) string {
+	pattern := ""
+	if m := regexp.MustCompile("productCodePattern\\s*=\\s*`([^`]+)`").FindStringSubmatch(src); m != nil {
+		pattern = m[1]
+	}
+
+	type fieldRules struct {
+		name, tsType string
+		rules        [][2]string
+	}
+	var fields []fieldRules
+	reField := regexp.MustCompile("^\\s*[A-Za-z0-9_]+\\s+([A-Za-z0-9_]+)\\s+`json:\"([a-z_]+)\"\\s+binding:\"([^\"]+)\"`")
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	inStruct := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "type productPayload struct") {
+			inStruct = true
+			continue
+		}
+		if !inStruct {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "}") {
+			break
+		}
+		m := reField.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		f := fieldRules{name: m[2], tsType: "number"}
+		if m[1] == "string" {
+			f.tsType = "string"
+		}
+		for _, rule := range strings.Split(m[3], ",") {
+			name, param, _ := strings.Cut(rule, "=")
+			f.rules = append(f.rules, [2]string{name, param})
+		}
+		fields = append(fields, f)
+	}
+
+	var b strings.Builder
+	b.WriteString("// GENERATED FROM the productPayload binding tags in backend/validation.go\n")
+	b.WriteString("// Keep in sync with backend; used by forms to validate products before submitting.\n\n")
+	b.WriteString("export interface FieldRule {\n  rule: string;\n  param?: string;\n}\n\n")
+	b.WriteString("export interface FieldError {\n  field: string;\n  rule: string;\n  param?: string;\n  message: string;\n}\n\n")
+	b.WriteString(fmt.Sprintf("export const ProductCodePattern = new RegExp(%s);\n\n", strconv.Quote(pattern)))
+	b.WriteString("export const ProductRules: Record<string, { type: \"string\" | \"number\"; rules: FieldRule[] }> = {\n")
+	for _, f := range fields {
+		b.WriteString(fmt.Sprintf("  %s: {\n    type: \"%s\",\n    rules: [\n", f.name, f.tsType))
+		for _, r := range f.rules {
+			if r[1] == "" {
+				b.WriteString(fmt.Sprintf("      { rule: \"%s\" },\n", r[0]))
+			} else {
+				b.WriteString(fmt.Sprintf("      { rule: \"%s\", param: \"%s\" },\n", r[0], r[1]))
+			}
+		}
+		b.WriteString("    ],\n  },\n")
+	}
+	b.WriteString("};\n\n")
+	b.WriteString(validateProductTS)
+	return b.String()
+}
+
+// validateProductTS checks values against ProductRules with the same
+// messages as fieldMessage in backend/validation.go. With partial set,
+// only the fields present in values are checked (as for PATCH).
+const validateProductTS = `export function validateProduct(
+  values: Record<string, string | number | undefined>,
+  partial = false,
+): FieldError[] {
+  const errors: FieldError[] = [];
+  for (const [field, { type, rules }] of Object.entries(ProductRules)) {
+    const value = values[field];
+    if (partial && value === undefined) continue;
+    const isString = type === "string";
+    const size = isString ? String(value ?? "").length : Number(value ?? 0);
+    for (const { rule, param } of rules) {
+      let message: string | null = null;
+      switch (rule) {
+        case "required":
+          if (isString ? size === 0 : !size) message = ` + "`${field} is required`" + `;
+          break;
+        case "min":
+          if (size < Number(param))
+            message = isString
+              ? ` + "`${field} must be at least ${param} characters long`" + `
+              : ` + "`${field} must be at least ${param}`" + `;
+          break;
+        case "max":
+          if (size > Number(param))
+            message = isString
+              ? ` + "`${field} must be at most ${param} characters long`" + `
+              : ` + "`${field} must be at most ${param}`" + `;
+          break;
+        case "productcode":
+          if (!ProductCodePattern.test(String(value ?? "")))
+            message = ` + "`${field} may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit`" + `;
+          break;
+      }
+      if (message) errors.push({ field, rule, ...(param ? { param } : {}), message });
+    }
+  }
+  return errors;
+}
+`
+
 type Field struct {
 	Name   string
 	TSType string
@@ -126,22 +339,25 @@ type Field struct {
 
 // parseProductFields does a small heuristic parse for `type Product struct` fields
 // and returns a slice of Field suitable for TypeScript generation. It also
-// injects the embedded gorm.Model fields as typical fields.
+// injects the embedded gorm.Model fields as typical fields. Names are the
+// JSON keys the API sends: the `json` tag when present, otherwise the Go
+// field name (gorm.Model has no tags, so its fields keep theirs).
 func parseProductFields(src string) []Field {
 	// default fields from gorm.Model
 	fields := []Field{
-		{Name: "id", TSType: "number"},
-		{Name: "code", TSType: "string"},
-		{Name: "price", TSType: "number"},
-		{Name: "createdAt", TSType: "string"},
-		{Name: "updatedAt", TSType: "string"},
-		{Name: "deletedAt", TSType: "string | null"},
+		{Name: "ID", TSType: "number"},
+		{Name: "Code", TSType: "string"},
+		{Name: "Price", TSType: "number"},
+		{Name: "CreatedAt", TSType: "string"},
+		{Name: "UpdatedAt", TSType: "string"},
+		{Name: "DeletedAt", TSType: "string | null"},
 	}
 
 	// try to parse explicit fields in the struct (e.g., Code string, Price uint)
 	scanner := bufio.NewScanner(strings.NewReader(src))
 	inStruct := false
-	reField := regexp.MustCompile(`^\s*([A-Za-z0-9_]+)\s+([A-Za-z0-9_\.\[\]]+)`)
+	reField := regexp.MustCompile(`^\s*([A-Za-z0-9_]+)\s+([A-Za-z0-9_\.\[\]\*]+)(?:\s+` + "`" + `([^` + "`" + `]*)` + "`" + `)?`)
+	reJSON := regexp.MustCompile(`json:"([^",]*)`)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(strings.TrimSpace(line), "type Product struct") {
@@ -156,35 +372,44 @@ func parseProductFields(src string) []Field {
 			if len(m) >= 3 {
 				name := m[1]
 				typ := m[2]
-				// skip gorm.Model embedded
-				if name == "gorm.Model" || name == "Model" {
-					continue
+				if tag := reJSON.FindStringSubmatch(m[3]); tag != nil {
+					if tag[1] == "-" {
+						continue
+					}
+					if tag[1] != "" {
+						name = tag[1]
+					}
 				}
 				// map Go types to TS
 				ts := "any"
-				switch typ {
+				switch strings.TrimPrefix(typ, "*") {
 				case "string":
 					ts = "string"
 				case "uint", "int", "uint32", "uint64", "int32", "int64":
 					ts = "number"
+				case "bool":
+					ts = "boolean"
+				case "time.Time":
+					ts = "string"
 				default:
 					if strings.HasPrefix(typ, "[]") {
 						ts = "any[]"
-					} else {
-						ts = "any"
 					}
 				}
+				if strings.HasPrefix(typ, "*") {
+					ts += " | null"
+				}
 				// replace or append field
 				replaced := false
 				for i := range fields {
-					if strings.EqualFold(fields[i].Name, name) {
+					if fields[i].Name == name {
 						fields[i].TSType = ts
 						replaced = true
 						break
 					}
 				}
 				if !replaced {
-					fields = append(fields, Field{Name: strings.ToLower(name[:1]) + name[1:], TSType: ts})
+					fields = append(fields, Field{Name: name, TSType: ts})
 				}
 			}
 		}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+	"gorm.io/gorm"
+
+	"may/dbconn"
+	"may/migrate"
+	"may/migrations"
+)
+
+const usage = `usage: migrate [flags] <command>
+
+commands:
+  up            apply all pending migrations
+  down [N]      roll back the N most recent migrations (default 1)
+  status        list migrations and whether they are applied
+  create NAME   write an empty up/down pair into -dir
+
+flags:
+`
+
+func main() {
+	dsn := flag.String("dsn", "", "database DSN, e.g. postgres://... or sqlite://dev.db (defaults to DATABASE_URL or POSTGRES_DSN)")
+	dir := flag.String("dir", "migrations", "migrations directory used by create")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if args[0] == "create" {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "create requires a migration name")
+			os.Exit(2)
+		}
+		up, down, err := migrate.Create(*dir, args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create migration: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("created %s\ncreated %s\n", up, down)
+		return
+	}
+
+	// Same .env lookup as the server.
+	if err := godotenv.Load("../.env"); err != nil {
+		_ = godotenv.Load()
+	}
+	if *dsn == "" {
+		*dsn = os.Getenv("DATABASE_URL")
+	}
+	if *dsn == "" {
+		*dsn = os.Getenv("POSTGRES_DSN")
+	}
+	if *dsn == "" {
+		fmt.Fprintln(os.Stderr, "no DSN given: pass -dsn or set DATABASE_URL or POSTGRES_DSN")
+		os.Exit(2)
+	}
+
+	db, err := dbconn.Open(*dsn, &gorm.Config{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to connect to database: %v\n", err)
+		os.Exit(1)
+	}
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load migrations: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "invalid number of migrations %q\n", args[1])
+				os.Exit(2)
+			}
+		}
+		reverted, err := m.Down(ctx, n)
+		for _, mig := range reverted {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -2,31 +2,139 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var database *gorm.DB
-var ctx = context.Background()
+// ProductRepository is the data access layer used by the HTTP handlers.
+// Every method takes the request context so cancellations and deadlines
+// reach the underlying store. Lookups of missing products return
+// gorm.ErrRecordNotFound regardless of the implementation.
+//
+// Update and Delete take the version the caller last saw (0 for any) and
+// fail with ErrVersionConflict if the product has changed since. Writes
+// that would give two live products the same code fail with
+// ErrCodeConflict.
+type ProductRepository interface {
+	Latest(ctx context.Context, by LatestBy, n int) ([]Product, error)
+	List(ctx context.Context, q ProductQuery, page int, perPage int) ([]Product, int64, error)
+	ListKeyset(ctx context.Context, q ProductQuery, cur *Cursor, limit int) ([]Product, error)
+	Count(ctx context.Context, q ProductQuery) (int64, error)
+	// Each calls fn for every product matching q in q's order, without
+	// holding them all in memory, and stops at the first error fn returns.
+	Each(ctx context.Context, q ProductQuery, fn func(Product) error) error
+	GetByID(ctx context.Context, id uint) (Product, error)
+	// GetByCodes returns the live products with any of the given codes.
+	GetByCodes(ctx context.Context, codes []string) ([]Product, error)
+	Create(ctx context.Context, code string, price uint) (Product, error)
+	Update(ctx context.Context, id uint, changes ProductChanges, ifVersion uint) (Product, error)
+	Delete(ctx context.Context, id uint, ifVersion uint) error
+	// UpsertByCode atomically updates the price of the live product with
+	// code, or creates it, and reports whether it was created.
+	UpsertByCode(ctx context.Context, code string, price uint) (Product, bool, error)
 
-func getLatestProduct() (Product, error) {
+	// ListDeleted returns a page of soft-deleted products, most recently
+	// deleted first, and their total count.
+	ListDeleted(ctx context.Context, page int, perPage int) ([]Product, int64, error)
+	// Restore undeletes a soft-deleted product.
+	Restore(ctx context.Context, id uint) (Product, error)
+	// Purge permanently removes a product, deleted or not.
+	Purge(ctx context.Context, id uint) error
+	// PurgeDeleted permanently removes products soft-deleted before cutoff.
+	PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error)
 
-	product, err := gorm.G[Product](database).First(ctx) // find product with integer primary key
-	if err != nil {
-		fmt.Println("Error fetching product:", err)
-		return Product{}, err
+	// Transaction runs fn against a repository whose writes are committed
+	// together if fn returns nil and discarded otherwise.
+	Transaction(ctx context.Context, fn func(tx ProductRepository) error) error
+}
+
+// ErrVersionConflict is returned when a product's version no longer
+// matches the one an update or delete was based on.
+var ErrVersionConflict = errors.New("product version conflict")
+
+// ErrCodeConflict is returned when a write would give a product the code
+// of another non-deleted product.
+var ErrCodeConflict = errors.New("product code already in use")
+
+// ProductChanges lists the fields to update; nil fields are left as is.
+type ProductChanges struct {
+	Code  *string
+	Price *uint
+}
+
+// apply copies the set fields onto p.
+func (ch ProductChanges) apply(p *Product) {
+	if ch.Code != nil {
+		p.Code = *ch.Code
 	}
-	return product, err
+	if ch.Price != nil {
+		p.Price = *ch.Price
+	}
+}
+
+// LatestBy names the timestamp column that orders "latest" products.
+type LatestBy string
+
+const (
+	LatestByCreatedAt LatestBy = "created_at"
+	LatestByUpdatedAt LatestBy = "updated_at"
+)
+
+// Valid reports whether by is one of the supported columns.
+func (by LatestBy) Valid() bool {
+	return by == LatestByCreatedAt || by == LatestByUpdatedAt
 }
 
-// getAllProducts returns a page of products and the total count.
-func getAllProducts(page int, perPage int) ([]Product, int64, error) {
+// gormProductRepository is the GORM-backed ProductRepository.
+type gormProductRepository struct {
+	db *gorm.DB
+}
+
+// NewGormProductRepository returns a ProductRepository backed by db.
+func NewGormProductRepository(db *gorm.DB) ProductRepository {
+	return &gormProductRepository{db: db}
+}
+
+// translate maps unique index violations to ErrCodeConflict, using the
+// dialector's error translation so driver errors never reach callers as is.
+func (r *gormProductRepository) translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if t, ok := r.db.Dialector.(gorm.ErrorTranslator); ok {
+		if errors.Is(t.Translate(err), gorm.ErrDuplicatedKey) {
+			return ErrCodeConflict
+		}
+	}
+	return err
+}
+
+// Latest returns up to n non-deleted products, newest first by the by
+// column. Ties on the timestamp are broken by descending id so the order
+// is deterministic.
+func (r *gormProductRepository) Latest(ctx context.Context, by LatestBy, n int) ([]Product, error) {
+	if !by.Valid() {
+		return nil, fmt.Errorf("invalid latest column %q", by)
+	}
+	var products []Product
+	err := r.db.WithContext(ctx).Order(string(by) + " DESC").Order("id DESC").Limit(n).Find(&products).Error
+	return products, err
+}
+
+// List returns a page of the products matching q and their total count.
+func (r *gormProductRepository) List(ctx context.Context, q ProductQuery, page int, perPage int) ([]Product, int64, error) {
 	var products []Product
 	var total int64
 
+	// Session makes the filtered statement safe to reuse for both queries.
+	db := q.apply(r.db.WithContext(ctx).Model(&Product{})).Session(&gorm.Session{})
+
 	// Count total products
-	if err := database.Model(&Product{}).Count(&total).Error; err != nil {
+	if err := db.Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
@@ -36,44 +144,215 @@ func getAllProducts(page int, perPage int) ([]Product, int64, error) {
 		offset = (page - 1) * perPage
 	}
 
-	if err := database.Limit(perPage).Offset(offset).Find(&products).Error; err != nil {
+	if err := q.order(db).Limit(perPage).Offset(offset).Find(&products).Error; err != nil {
 		return nil, 0, err
 	}
 
 	return products, total, nil
 }
 
-func getProductByID(id string) (Product, error) {
-	product, err := gorm.G[Product](database).Where("id = ?", id).Take(ctx) // find product with integer primary key
-	if err != nil {
-		fmt.Println("Error fetching product:", err)
-		return Product{}, err
+// ListKeyset returns up to limit products matching q that come after cur
+// (or before it, when cur.Before is set) in q's keyset order. Rows are
+// returned in scan order, i.e. nearest to the cursor first.
+func (r *gormProductRepository) ListKeyset(ctx context.Context, q ProductQuery, cur *Cursor, limit int) ([]Product, error) {
+	sort := q.keysetSort(cur != nil && cur.Before)
+
+	db := q.apply(r.db.WithContext(ctx).Model(&Product{}))
+	if cur != nil {
+		cond, args := keysetWhere(sort, cur.Values)
+		db = db.Where(cond, args...)
+	}
+	for _, s := range sort {
+		if s.Desc {
+			db = db.Order(s.Field + " DESC")
+		} else {
+			db = db.Order(s.Field + " ASC")
+		}
+	}
+
+	var products []Product
+	err := db.Limit(limit).Find(&products).Error
+	return products, err
+}
+
+// Count returns the number of products matching q.
+func (r *gormProductRepository) Count(ctx context.Context, q ProductQuery) (int64, error) {
+	var total int64
+	err := q.apply(r.db.WithContext(ctx).Model(&Product{})).Count(&total).Error
+	return total, err
+}
+
+// eachPageSize is the number of rows Each reads per query.
+const eachPageSize = 500
+
+// Each reads the matching rows in keyset pages of eachPageSize rather than
+// from one long-lived cursor, so no connection is held while fn runs: on
+// SQLite, whose pool has a single connection, other requests keep being
+// served while a slow client downloads an export. Pages are separate
+// reads, so rows changed during the walk may be seen in either state.
+func (r *gormProductRepository) Each(ctx context.Context, q ProductQuery, fn func(Product) error) error {
+	sort := q.keysetSort(false)
+	var cur *Cursor
+	for {
+		products, err := r.ListKeyset(ctx, q, cur, eachPageSize)
+		if err != nil {
+			return err
+		}
+		for _, p := range products {
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+		if len(products) < eachPageSize {
+			return nil
+		}
+		cur = &Cursor{Values: keysetValues(products[len(products)-1], sort)}
 	}
-	return product, err
 }
 
-// addProduct creates a product and returns it.
-func addProduct(code string, price uint) (Product, error) {
+func (r *gormProductRepository) GetByID(ctx context.Context, id uint) (Product, error) {
+	return gorm.G[Product](r.db).Where("id = ?", id).Take(ctx)
+}
+
+func (r *gormProductRepository) GetByCodes(ctx context.Context, codes []string) ([]Product, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	var products []Product
+	err := r.db.WithContext(ctx).Where("code IN ?", codes).Find(&products).Error
+	return products, err
+}
+
+// Create creates a product and returns it.
+func (r *gormProductRepository) Create(ctx context.Context, code string, price uint) (Product, error) {
 	product := Product{Code: code, Price: price}
-	result := database.Create(&product)
-	return product, result.Error
+	result := r.db.WithContext(ctx).Create(&product)
+	return product, r.translate(result.Error)
 }
 
-// updateProduct updates fields of a product and returns the updated product.
-func updateProduct(id uint, newCode string, newPrice uint) (Product, error) {
+// Update applies changes to a product and returns the updated product.
+// The write is conditional on the version that was read, so concurrent
+// updates can't silently overwrite each other.
+func (r *gormProductRepository) Update(ctx context.Context, id uint, changes ProductChanges, ifVersion uint) (Product, error) {
+	db := r.db.WithContext(ctx)
+
 	var product Product
-	result := database.First(&product, id)
+	result := db.First(&product, id)
 	if result.Error != nil {
 		return Product{}, result.Error
 	}
-	product.Code = newCode
-	product.Price = newPrice
-	result = database.Save(&product)
-	return product, result.Error
+	if ifVersion != 0 && product.Version != ifVersion {
+		return Product{}, ErrVersionConflict
+	}
+
+	changes.apply(&product)
+	now := time.Now()
+	result = db.Model(&Product{}).
+		Where("id = ? AND version = ?", id, product.Version).
+		Updates(map[string]interface{}{
+			"code":       product.Code,
+			"price":      product.Price,
+			"version":    gorm.Expr("version + 1"),
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return Product{}, r.translate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return Product{}, ErrVersionConflict
+	}
+	product.Version++
+	product.UpdatedAt = now
+	return product, nil
+}
+
+func (r *gormProductRepository) Delete(ctx context.Context, id uint, ifVersion uint) error {
+	db := r.db.WithContext(ctx)
+	if ifVersion != 0 {
+		db = db.Where("version = ?", ifVersion)
+	}
+	result := db.Delete(&Product{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if ifVersion != 0 {
+			// distinguish a stale version from a missing product
+			if _, err := r.GetByID(ctx, id); err == nil {
+				return ErrVersionConflict
+			}
+		}
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpsertByCode inserts the product or, if a live product already has the
+// code, bumps its price and version in the same statement. Products start
+// at version 1 and every update increments it, so the returned version
+// tells the two cases apart.
+func (r *gormProductRepository) UpsertByCode(ctx context.Context, code string, price uint) (Product, bool, error) {
+	now := time.Now()
+	product := Product{Code: code, Price: price}
+	result := r.db.WithContext(ctx).Clauses(
+		clause.OnConflict{
+			Columns:     []clause.Column{{Name: "code"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "deleted_at IS NULL"}}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"price":      price,
+				"version":    gorm.Expr("products.version + 1"),
+				"updated_at": now,
+			}),
+		},
+		clause.Returning{},
+	).Create(&product)
+	if result.Error != nil {
+		return Product{}, false, r.translate(result.Error)
+	}
+	return product, product.Version == 1, nil
+}
+
+func (r *gormProductRepository) ListDeleted(ctx context.Context, page int, perPage int) ([]Product, int64, error) {
+	var products []Product
+	var total int64
+
+	db := r.db.WithContext(ctx).Unscoped().Model(&Product{}).Where("deleted_at IS NOT NULL").Session(&gorm.Session{})
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := 0
+	if page > 0 {
+		offset = (page - 1) * perPage
+	}
+	if err := db.Order("deleted_at DESC").Order("id DESC").Limit(perPage).Offset(offset).Find(&products).Error; err != nil {
+		return nil, 0, err
+	}
+	return products, total, nil
 }
 
-func deleteProduct(id uint) error {
-	result := database.Delete(&Product{}, id)
+// Restore clears DeletedAt and bumps the version, so ETags taken before
+// the delete no longer match. It fails with ErrCodeConflict if a live
+// product has taken the code in the meantime.
+func (r *gormProductRepository) Restore(ctx context.Context, id uint) (Product, error) {
+	result := r.db.WithContext(ctx).Unscoped().Model(&Product{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Updates(map[string]interface{}{
+			"deleted_at": nil,
+			"version":    gorm.Expr("version + 1"),
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return Product{}, r.translate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return Product{}, gorm.ErrRecordNotFound
+	}
+	return r.GetByID(ctx, id)
+}
+
+func (r *gormProductRepository) Purge(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Unscoped().Delete(&Product{}, id)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -82,3 +361,16 @@ func deleteProduct(id uint) error {
 	}
 	return nil
 }
+
+func (r *gormProductRepository) PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Delete(&Product{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *gormProductRepository) Transaction(ctx context.Context, fn func(tx ProductRepository) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&gormProductRepository{db: tx})
+	})
+}
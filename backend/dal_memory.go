@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// memoryProductRepository is an in-memory ProductRepository intended for
+// tests and demos. It mirrors the GORM implementation's behaviour,
+// including soft deletes via DeletedAt.
+type memoryProductRepository struct {
+	mu       sync.RWMutex
+	nextID   uint
+	products map[uint]Product
+}
+
+// NewMemoryProductRepository returns an empty in-memory ProductRepository.
+func NewMemoryProductRepository() ProductRepository {
+	return &memoryProductRepository{products: map[uint]Product{}}
+}
+
+// codeTaken reports whether a live product other than id has code.
+// Callers must hold r.mu.
+func (r *memoryProductRepository) codeTaken(code string, id uint) bool {
+	for _, p := range r.products {
+		if p.ID != id && !p.DeletedAt.Valid && p.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// active returns the non-deleted products ordered by primary key.
+// Callers must hold r.mu.
+func (r *memoryProductRepository) active() []Product {
+	out := make([]Product, 0, len(r.products))
+	for _, p := range r.products {
+		if p.DeletedAt.Valid {
+			continue
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (r *memoryProductRepository) Latest(ctx context.Context, by LatestBy, n int) ([]Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !by.Valid() {
+		return nil, fmt.Errorf("invalid latest column %q", by)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	products := r.active()
+	stamp := func(p Product) time.Time {
+		if by == LatestByUpdatedAt {
+			return p.UpdatedAt
+		}
+		return p.CreatedAt
+	}
+	sort.SliceStable(products, func(i, j int) bool {
+		ti, tj := stamp(products[i]), stamp(products[j])
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return products[i].ID > products[j].ID
+	})
+	if n < len(products) {
+		products = products[:n]
+	}
+	return products, nil
+}
+
+func (r *memoryProductRepository) List(ctx context.Context, q ProductQuery, page int, perPage int) ([]Product, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var products []Product
+	for _, p := range r.active() {
+		if q.Match(p) {
+			products = append(products, p)
+		}
+	}
+	slices.SortStableFunc(products, q.Compare)
+	total := int64(len(products))
+
+	offset := 0
+	if page > 0 {
+		offset = (page - 1) * perPage
+	}
+	if offset >= len(products) {
+		return []Product{}, total, nil
+	}
+	end := offset + perPage
+	if end > len(products) {
+		end = len(products)
+	}
+	return products[offset:end], total, nil
+}
+
+func (r *memoryProductRepository) ListKeyset(ctx context.Context, q ProductQuery, cur *Cursor, limit int) ([]Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sort := q.keysetSort(cur != nil && cur.Before)
+	var products []Product
+	for _, p := range r.active() {
+		if !q.Match(p) {
+			continue
+		}
+		if cur != nil && compareKeyset(p, sort, cur.Values) <= 0 {
+			continue
+		}
+		products = append(products, p)
+	}
+	slices.SortStableFunc(products, func(a, b Product) int {
+		return compareKeyset(a, sort, keysetValues(b, sort))
+	})
+	if limit < len(products) {
+		products = products[:limit]
+	}
+	return products, nil
+}
+
+func (r *memoryProductRepository) Count(ctx context.Context, q ProductQuery) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var total int64
+	for _, p := range r.active() {
+		if q.Match(p) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// Each calls fn on a snapshot, so fn may use the repository itself.
+func (r *memoryProductRepository) Each(ctx context.Context, q ProductQuery, fn func(Product) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.RLock()
+	var products []Product
+	for _, p := range r.active() {
+		if q.Match(p) {
+			products = append(products, p)
+		}
+	}
+	r.mu.RUnlock()
+
+	slices.SortStableFunc(products, q.Compare)
+	for _, p := range products {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryProductRepository) GetByID(ctx context.Context, id uint) (Product, error) {
+	if err := ctx.Err(); err != nil {
+		return Product{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.products[id]
+	if !ok || p.DeletedAt.Valid {
+		return Product{}, gorm.ErrRecordNotFound
+	}
+	return p, nil
+}
+
+func (r *memoryProductRepository) GetByCodes(ctx context.Context, codes []string) ([]Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var products []Product
+	for _, p := range r.active() {
+		if slices.Contains(codes, p.Code) {
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
+
+func (r *memoryProductRepository) Create(ctx context.Context, code string, price uint) (Product, error) {
+	if err := ctx.Err(); err != nil {
+		return Product{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.codeTaken(code, 0) {
+		return Product{}, ErrCodeConflict
+	}
+	r.nextID++
+	now := time.Now()
+	p := Product{Code: code, Price: price, Version: 1}
+	p.ID = r.nextID
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	r.products[p.ID] = p
+	return p, nil
+}
+
+func (r *memoryProductRepository) Update(ctx context.Context, id uint, changes ProductChanges, ifVersion uint) (Product, error) {
+	if err := ctx.Err(); err != nil {
+		return Product{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.products[id]
+	if !ok || p.DeletedAt.Valid {
+		return Product{}, gorm.ErrRecordNotFound
+	}
+	if ifVersion != 0 && p.Version != ifVersion {
+		return Product{}, ErrVersionConflict
+	}
+	if changes.Code != nil && r.codeTaken(*changes.Code, id) {
+		return Product{}, ErrCodeConflict
+	}
+	changes.apply(&p)
+	p.Version++
+	p.UpdatedAt = time.Now()
+	r.products[id] = p
+	return p, nil
+}
+
+func (r *memoryProductRepository) Delete(ctx context.Context, id uint, ifVersion uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.products[id]
+	if !ok || p.DeletedAt.Valid {
+		return gorm.ErrRecordNotFound
+	}
+	if ifVersion != 0 && p.Version != ifVersion {
+		return ErrVersionConflict
+	}
+	p.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	r.products[id] = p
+	return nil
+}
+
+func (r *memoryProductRepository) UpsertByCode(ctx context.Context, code string, price uint) (Product, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Product{}, false, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, p := range r.products {
+		if p.DeletedAt.Valid || p.Code != code {
+			continue
+		}
+		p.Price = price
+		p.Version++
+		p.UpdatedAt = time.Now()
+		r.products[id] = p
+		return p, false, nil
+	}
+
+	r.nextID++
+	now := time.Now()
+	p := Product{Code: code, Price: price, Version: 1}
+	p.ID = r.nextID
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	r.products[p.ID] = p
+	return p, true, nil
+}
+
+func (r *memoryProductRepository) ListDeleted(ctx context.Context, page int, perPage int) ([]Product, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var products []Product
+	for _, p := range r.products {
+		if p.DeletedAt.Valid {
+			products = append(products, p)
+		}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		ti, tj := products[i].DeletedAt.Time, products[j].DeletedAt.Time
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return products[i].ID > products[j].ID
+	})
+	total := int64(len(products))
+
+	offset := 0
+	if page > 0 {
+		offset = (page - 1) * perPage
+	}
+	if offset >= len(products) {
+		return []Product{}, total, nil
+	}
+	end := offset + perPage
+	if end > len(products) {
+		end = len(products)
+	}
+	return products[offset:end], total, nil
+}
+
+func (r *memoryProductRepository) Restore(ctx context.Context, id uint) (Product, error) {
+	if err := ctx.Err(); err != nil {
+		return Product{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.products[id]
+	if !ok || !p.DeletedAt.Valid {
+		return Product{}, gorm.ErrRecordNotFound
+	}
+	if r.codeTaken(p.Code, id) {
+		return Product{}, ErrCodeConflict
+	}
+	p.DeletedAt = gorm.DeletedAt{}
+	p.Version++
+	p.UpdatedAt = time.Now()
+	r.products[id] = p
+	return p, nil
+}
+
+func (r *memoryProductRepository) Purge(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.products[id]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(r.products, id)
+	return nil
+}
+
+func (r *memoryProductRepository) PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for id, p := range r.products {
+		if p.DeletedAt.Valid && p.DeletedAt.Time.Before(cutoff) {
+			delete(r.products, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Transaction runs fn against a copy of the store and swaps the copy in
+// if fn succeeds. The store stays locked meanwhile, so transactions are
+// fully isolated from other callers.
+func (r *memoryProductRepository) Transaction(ctx context.Context, fn func(tx ProductRepository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx := &memoryProductRepository{nextID: r.nextID, products: make(map[uint]Product, len(r.products))}
+	for id, p := range r.products {
+		tx.products[id] = p
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	r.nextID = tx.nextID
+	r.products = tx.products
+	return nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// repositories returns a fresh instance of every ProductRepository
+// implementation so the same contract can be checked against each.
+func repositories(t *testing.T) map[string]ProductRepository {
+	return map[string]ProductRepository{
+		"gorm":   NewGormProductRepository(openTestDB(t)),
+		"memory": NewMemoryProductRepository(),
+	}
+}
+
+func TestProductRepositoryCRUD(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			created, err := repo.Create(ctx, "a", 1)
+			if err != nil {
+				t.Fatalf("create failed: %v", err)
+			}
+			if created.ID == 0 {
+				t.Fatalf("expected created product to have an id")
+			}
+			if _, err := repo.Create(ctx, "b", 2); err != nil {
+				t.Fatalf("create failed: %v", err)
+			}
+
+			latest, err := repo.Latest(ctx, LatestByCreatedAt, 10)
+			if err != nil || len(latest) != 2 || latest[0].Code != "b" {
+				t.Fatalf("latest mismatch: %+v, %v", latest, err)
+			}
+
+			got, err := repo.GetByID(ctx, created.ID)
+			if err != nil || got.Code != "a" {
+				t.Fatalf("get by id mismatch: %+v, %v", got, err)
+			}
+
+			updated, err := repo.Update(ctx, created.ID, ProductChanges{Code: ptr("a2"), Price: ptr(uint(10))}, 0)
+			if err != nil || updated.Code != "a2" || updated.Price != 10 {
+				t.Fatalf("update mismatch: %+v, %v", updated, err)
+			}
+
+			products, total, err := repo.List(ctx, ProductQuery{}, 1, 1)
+			if err != nil || total != 2 || len(products) != 1 {
+				t.Fatalf("list mismatch: %d products, total %d, %v", len(products), total, err)
+			}
+
+			if err := repo.Delete(ctx, created.ID, 0); err != nil {
+				t.Fatalf("delete failed: %v", err)
+			}
+			if _, err := repo.GetByID(ctx, created.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("expected ErrRecordNotFound after delete, got %v", err)
+			}
+			if err := repo.Delete(ctx, created.ID, 0); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("expected ErrRecordNotFound deleting twice, got %v", err)
+			}
+			if _, err := repo.Update(ctx, 999, ProductChanges{Code: ptr("x")}, 0); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("expected ErrRecordNotFound updating missing product, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProductRepositoryCanceledContext(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			if _, err := repo.Create(ctx, "a", 1); err == nil {
+				t.Fatalf("expected error creating with canceled context")
+			}
+		})
+	}
+}
+
+func TestProductRepositoryListQuery(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, p := range []struct {
+				code  string
+				price uint
+			}{{"ABC-1", 300}, {"abc-2", 100}, {"XYZ-1", 200}, {"A_C", 200}, {"ZZZ", 50}} {
+				if _, err := repo.Create(ctx, p.code, p.price); err != nil {
+					t.Fatalf("create failed: %v", err)
+				}
+			}
+
+			values, _ := url.ParseQuery("sort=-price,code&price[gte]=100&price[lt]=300")
+			q, qerr := parseProductQuery(values)
+			if qerr != nil {
+				t.Fatalf("parse failed: %v", qerr)
+			}
+			products, total, err := repo.List(ctx, q, 1, 10)
+			if err != nil {
+				t.Fatalf("list failed: %v", err)
+			}
+			if got := codes(products); total != 3 || got != "A_C,XYZ-1,abc-2" {
+				t.Fatalf("unexpected range result: total %d, %s", total, got)
+			}
+
+			values, _ = url.ParseQuery("code[prefix]=abc")
+			q, _ = parseProductQuery(values)
+			products, _, _ = repo.List(ctx, q, 1, 10)
+			if got := codes(products); got != "ABC-1,abc-2" {
+				t.Fatalf("unexpected prefix result: %s", got)
+			}
+
+			// LIKE wildcards in user input match literally
+			values, _ = url.ParseQuery("q=_")
+			q, _ = parseProductQuery(values)
+			products, _, _ = repo.List(ctx, q, 1, 10)
+			if got := codes(products); got != "A_C" {
+				t.Fatalf("unexpected search result: %s", got)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func codes(products []Product) string {
+	out := make([]string, 0, len(products))
+	for _, p := range products {
+		out = append(out, p.Code)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestProductRepositoryListKeyset(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			// prices repeat so pages must fall back to id to stay stable
+			for i, price := range []uint{5, 3, 5, 1, 3, 5} {
+				if _, err := repo.Create(ctx, "p"+strconv.Itoa(i+1), price); err != nil {
+					t.Fatalf("create failed: %v", err)
+				}
+			}
+			q := ProductQuery{Sort: []SortField{{Field: "price", Desc: true}}}
+
+			var seen []string
+			var cur *Cursor
+			for {
+				page, err := repo.ListKeyset(ctx, q, cur, 4)
+				if err != nil {
+					t.Fatalf("list keyset failed: %v", err)
+				}
+				if len(page) == 0 {
+					break
+				}
+				seen = append(seen, codes(page))
+				last := page[len(page)-1]
+				cur = &Cursor{Values: keysetValues(last, q.keysetSort(false))}
+			}
+			if got := strings.Join(seen, "|"); got != "p1,p3,p6,p2|p5,p4" {
+				t.Fatalf("unexpected forward pages: %s", got)
+			}
+
+			// reading backwards from p5 returns the nearest rows first
+			p5 := Product{Code: "p5", Price: 3}
+			p5.ID = 5
+			back, err := repo.ListKeyset(ctx, q, &Cursor{Values: keysetValues(p5, q.keysetSort(false)), Before: true}, 2)
+			if err != nil {
+				t.Fatalf("list keyset failed: %v", err)
+			}
+			if got := codes(back); got != "p2,p6" {
+				t.Fatalf("unexpected backward page: %s", got)
+			}
+
+			total, err := repo.Count(ctx, ProductQuery{Filters: []Filter{{Field: "price", Op: OpEq, Value: uint64(5)}}})
+			if err != nil || total != 3 {
+				t.Fatalf("expected count 3, got %d, %v", total, err)
+			}
+		})
+	}
+}
+
+func TestProductRepositoryVersionConflict(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			created, err := repo.Create(ctx, "a", 1)
+			if err != nil || created.Version != 1 {
+				t.Fatalf("expected version 1 on create, got %+v, %v", created, err)
+			}
+
+			updated, err := repo.Update(ctx, created.ID, ProductChanges{Price: ptr(uint(2))}, 1)
+			if err != nil || updated.Version != 2 {
+				t.Fatalf("expected version 2 after update, got %+v, %v", updated, err)
+			}
+
+			// a second writer still holding version 1 must not win
+			if _, err := repo.Update(ctx, created.ID, ProductChanges{Price: ptr(uint(3))}, 1); !errors.Is(err, ErrVersionConflict) {
+				t.Fatalf("expected ErrVersionConflict for stale update, got %v", err)
+			}
+			if err := repo.Delete(ctx, created.ID, 1); !errors.Is(err, ErrVersionConflict) {
+				t.Fatalf("expected ErrVersionConflict for stale delete, got %v", err)
+			}
+			if got, _ := repo.GetByID(ctx, created.ID); got.Price != 2 || got.Version != 2 {
+				t.Fatalf("stale writes must not change the product: %+v", got)
+			}
+
+			if err := repo.Delete(ctx, created.ID, 2); err != nil {
+				t.Fatalf("delete with current version failed: %v", err)
+			}
+			if err := repo.Delete(ctx, created.ID, 2); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("expected ErrRecordNotFound deleting twice, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProductRepositoryCodeConflict(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			a, _ := repo.Create(ctx, "a", 1)
+			b, _ := repo.Create(ctx, "b", 2)
+			if _, err := repo.Create(ctx, "a", 3); !errors.Is(err, ErrCodeConflict) {
+				t.Fatalf("expected ErrCodeConflict creating duplicate, got %v", err)
+			}
+			if _, err := repo.Update(ctx, b.ID, ProductChanges{Code: ptr("a")}, 0); !errors.Is(err, ErrCodeConflict) {
+				t.Fatalf("expected ErrCodeConflict renaming to duplicate, got %v", err)
+			}
+
+			// a soft-deleted product's code is free again
+			if err := repo.Delete(ctx, a.ID, 0); err != nil {
+				t.Fatalf("delete failed: %v", err)
+			}
+			if _, err := repo.Create(ctx, "a", 4); err != nil {
+				t.Fatalf("expected code of deleted product to be reusable, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProductRepositoryUpsertByCode(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			created, isNew, err := repo.UpsertByCode(ctx, "u", 1)
+			if err != nil || !isNew || created.ID == 0 || created.Version != 1 {
+				t.Fatalf("expected create, got %+v, %v, %v", created, isNew, err)
+			}
+			updated, isNew, err := repo.UpsertByCode(ctx, "u", 5)
+			if err != nil || isNew || updated.ID != created.ID || updated.Price != 5 || updated.Version != 2 {
+				t.Fatalf("expected update, got %+v, %v, %v", updated, isNew, err)
+			}
+			if total, _ := repo.Count(ctx, ProductQuery{}); total != 1 {
+				t.Fatalf("expected a single product, got %d", total)
+			}
+
+			if err := repo.Delete(ctx, created.ID, 0); err != nil {
+				t.Fatalf("delete failed: %v", err)
+			}
+			recreated, isNew, err := repo.UpsertByCode(ctx, "u", 7)
+			if err != nil || !isNew || recreated.ID == created.ID {
+				t.Fatalf("expected a new product after delete, got %+v, %v, %v", recreated, isNew, err)
+			}
+		})
+	}
+}
+
+func TestProductRepositoryTrash(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			a, _ := repo.Create(ctx, "a", 1)
+			b, _ := repo.Create(ctx, "b", 2)
+			if err := repo.Delete(ctx, a.ID, 0); err != nil {
+				t.Fatalf("delete failed: %v", err)
+			}
+			if err := repo.Delete(ctx, b.ID, 0); err != nil {
+				t.Fatalf("delete failed: %v", err)
+			}
+
+			trash, total, err := repo.ListDeleted(ctx, 1, 10)
+			if err != nil || total != 2 || codes(trash) != "b,a" {
+				t.Fatalf("trash mismatch: %s, total %d, %v", codes(trash), total, err)
+			}
+
+			restored, err := repo.Restore(ctx, a.ID)
+			if err != nil || restored.Code != "a" || restored.Version != a.Version+1 {
+				t.Fatalf("restore mismatch: %+v, %v", restored, err)
+			}
+			if _, err := repo.Restore(ctx, a.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("expected ErrRecordNotFound restoring a live product, got %v", err)
+			}
+
+			// restoring is refused while another live product has the code
+			if _, err := repo.Create(ctx, "b", 3); err != nil {
+				t.Fatalf("create failed: %v", err)
+			}
+			if _, err := repo.Restore(ctx, b.ID); !errors.Is(err, ErrCodeConflict) {
+				t.Fatalf("expected ErrCodeConflict restoring a taken code, got %v", err)
+			}
+
+			if n, err := repo.PurgeDeleted(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+				t.Fatalf("expected nothing purged before retention, got %d, %v", n, err)
+			}
+			if n, err := repo.PurgeDeleted(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+				t.Fatalf("expected 1 purged, got %d, %v", n, err)
+			}
+			if _, total, _ := repo.ListDeleted(ctx, 1, 10); total != 0 {
+				t.Fatalf("expected empty trash, got %d", total)
+			}
+
+			if err := repo.Purge(ctx, a.ID); err != nil {
+				t.Fatalf("purge failed: %v", err)
+			}
+			if err := repo.Purge(ctx, a.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("expected ErrRecordNotFound purging twice, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProductRepositoryTransaction(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			rollback := errors.New("rollback")
+
+			err := repo.Transaction(ctx, func(tx ProductRepository) error {
+				if _, err := tx.Create(ctx, "a", 1); err != nil {
+					return err
+				}
+				return rollback
+			})
+			if !errors.Is(err, rollback) {
+				t.Fatalf("expected fn error, got %v", err)
+			}
+			if total, _ := repo.Count(ctx, ProductQuery{}); total != 0 {
+				t.Fatalf("expected rollback to discard writes, got %d products", total)
+			}
+
+			err = repo.Transaction(ctx, func(tx ProductRepository) error {
+				_, err := tx.Create(ctx, "a", 1)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("transaction failed: %v", err)
+			}
+			if total, _ := repo.Count(ctx, ProductQuery{}); total != 1 {
+				t.Fatalf("expected commit to keep writes, got %d products", total)
+			}
+		})
+	}
+}
+
+func TestProductRepositoryEach(t *testing.T) {
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for i, price := range []uint{3, 1, 2} {
+				if _, err := repo.Create(ctx, "p"+strconv.Itoa(i), price); err != nil {
+					t.Fatalf("create failed: %v", err)
+				}
+			}
+
+			var seen []Product
+			err := repo.Each(ctx, ProductQuery{Sort: []SortField{{Field: "price"}}}, func(p Product) error {
+				seen = append(seen, p)
+				return nil
+			})
+			if err != nil || codes(seen) != "p1,p2,p0" {
+				t.Fatalf("each mismatch: %s, %v", codes(seen), err)
+			}
+
+			stop := errors.New("stop")
+			calls := 0
+			err = repo.Each(ctx, ProductQuery{}, func(Product) error {
+				calls++
+				return stop
+			})
+			if !errors.Is(err, stop) || calls != 1 {
+				t.Fatalf("expected Each to stop at the first error, got %v after %d calls", err, calls)
+			}
+
+			// Each walks past its first page, and holds no connection while
+			// fn runs, so fn (and everyone else) can still query
+			for i := 3; i <= eachPageSize; i++ {
+				if _, err := repo.Create(ctx, "p"+strconv.Itoa(i), 4); err != nil {
+					t.Fatalf("create failed: %v", err)
+				}
+			}
+			ids := map[uint]bool{}
+			err = repo.Each(ctx, ProductQuery{Sort: []SortField{{Field: "price"}}}, func(p Product) error {
+				qctx, cancel := context.WithTimeout(ctx, time.Second)
+				defer cancel()
+				if _, err := repo.GetByID(qctx, p.ID); err != nil {
+					return err
+				}
+				ids[p.ID] = true
+				return nil
+			})
+			if err != nil || len(ids) != eachPageSize+1 {
+				t.Fatalf("expected %d distinct products, got %d: %v", eachPageSize+1, len(ids), err)
+			}
+		})
+	}
+}
@@ -1,38 +1,54 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 	"os"
+	"time"
 
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+
+	"may/dbconn"
+	"may/migrate"
+	"may/migrations"
 )
 
 func db() *gorm.DB {
 
 	// Load DSN from environment variables to avoid committing secrets.
 	// Supports `DATABASE_URL` (common) or `POSTGRES_DSN` (as used in README).
+	// The driver is chosen from the DSN scheme, e.g. `postgres://...`,
+	// `sqlite://dev.db` or `sqlite::memory:`.
 	dsn := os.Getenv("DATABASE_URL")
 	if dsn == "" {
 		dsn = os.Getenv("POSTGRES_DSN")
 	}
 	if dsn == "" {
-		log.Fatal("DATABASE_URL or POSTGRES_DSN is not set")
+		fatal("DATABASE_URL or POSTGRES_DSN is not set", nil)
 	}
 
 	// Connect to the database
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// GORM logs through slog, with statements slower than 200ms as warnings.
+	db, err := dbconn.Open(dsn, &gorm.Config{Logger: newGormLogger(slog.Default(), 200*time.Millisecond)})
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("failed to connect to database", err)
 	}
 
-	fmt.Println("Connected to PostgreSQL database!")
+	slog.Info("connected to database", slog.String("driver", db.Dialector.Name()))
 
 	return db
+}
 
-	// ctx := context.Background()
-
-	// // Migrate the schema
-	// db.AutoMigrate(&Product{})
+// runMigrations applies any pending schema migrations from the embedded
+// migrations package. The schema is otherwise managed with `cmd/migrate`.
+func runMigrations(db *gorm.DB) error {
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up(context.Background())
+	for _, mig := range applied {
+		slog.Info("applied migration", slog.Int64("version", mig.Version), slog.String("name", mig.Name))
+	}
+	return err
 }
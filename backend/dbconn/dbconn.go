@@ -0,0 +1,137 @@
+// Package dbconn opens a GORM connection for a DSN, choosing the driver
+// from the DSN scheme and configuring the connection pool from env.
+package dbconn
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Supported driver names, as reported by the GORM dialectors.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// PoolConfig holds the database/sql connection pool settings.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// defaultPools are the per-driver pool defaults. SQLite uses a single
+// connection: every connection to ":memory:" is a separate database, and a
+// single writer avoids SQLITE_BUSY errors on files.
+var defaultPools = map[string]PoolConfig{
+	DriverPostgres: {MaxOpenConns: 25, MaxIdleConns: 10, ConnMaxLifetime: 30 * time.Minute, ConnMaxIdleTime: 5 * time.Minute},
+	DriverSQLite:   {MaxOpenConns: 1, MaxIdleConns: 1},
+}
+
+// Parse returns the driver name and the driver-specific DSN for dsn.
+//
+//	postgres://... or postgresql://...   -> postgres, unchanged
+//	host=... user=... (keyword form)     -> postgres, unchanged
+//	sqlite://file.db                     -> sqlite, "file.db"
+//	sqlite::memory:                      -> sqlite, ":memory:"
+func Parse(dsn string) (string, string, error) {
+	dsn = strings.TrimSpace(dsn)
+	switch {
+	case dsn == "":
+		return "", "", fmt.Errorf("empty DSN")
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return DriverPostgres, dsn, nil
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return sqliteDSN(strings.TrimPrefix(dsn, "sqlite://"))
+	case strings.HasPrefix(dsn, "sqlite:"):
+		return sqliteDSN(strings.TrimPrefix(dsn, "sqlite:"))
+	case !strings.Contains(dsn, "://") && strings.Contains(dsn, "="):
+		return DriverPostgres, dsn, nil
+	}
+	scheme, _, _ := strings.Cut(dsn, ":")
+	return "", "", fmt.Errorf("unsupported database scheme %q", scheme)
+}
+
+func sqliteDSN(path string) (string, string, error) {
+	if path == "" {
+		return "", "", fmt.Errorf("sqlite DSN has no file path")
+	}
+	return DriverSQLite, path, nil
+}
+
+// PoolConfigFromEnv returns the pool settings for driver: the driver
+// default, overridden by DB_* variables, overridden in turn by
+// driver-prefixed ones (e.g. POSTGRES_MAX_OPEN_CONNS, SQLITE_MAX_OPEN_CONNS).
+// Invalid values are ignored.
+//
+//	*_MAX_OPEN_CONNS, *_MAX_IDLE_CONNS        integers
+//	*_CONN_MAX_LIFETIME, *_CONN_MAX_IDLE_TIME durations such as "30m"
+func PoolConfigFromEnv(driver string) PoolConfig {
+	cfg := defaultPools[driver]
+	prefixes := []string{"DB_", strings.ToUpper(driver) + "_"}
+	for _, p := range prefixes {
+		envInt(p+"MAX_OPEN_CONNS", &cfg.MaxOpenConns)
+		envInt(p+"MAX_IDLE_CONNS", &cfg.MaxIdleConns)
+		envDuration(p+"CONN_MAX_LIFETIME", &cfg.ConnMaxLifetime)
+		envDuration(p+"CONN_MAX_IDLE_TIME", &cfg.ConnMaxIdleTime)
+	}
+	return cfg
+}
+
+func envInt(key string, dst *int) {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			*dst = n
+		}
+	}
+}
+
+func envDuration(key string, dst *time.Duration) {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			*dst = d
+		}
+	}
+}
+
+// Open connects to dsn with the driver selected by its scheme and applies
+// the pool settings from PoolConfigFromEnv.
+func Open(dsn string, config *gorm.Config) (*gorm.DB, error) {
+	driver, driverDSN, err := Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverPostgres:
+		dialector = postgres.Open(driverDSN)
+	case DriverSQLite:
+		dialector = sqlite.Open(driverDSN)
+	}
+
+	db, err := gorm.Open(dialector, config)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	pool := PoolConfigFromEnv(driver)
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+
+	return db, nil
+}
@@ -0,0 +1,73 @@
+package dbconn
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		dsn, driver, driverDSN string
+	}{
+		{"postgres://u:p@localhost:5432/db?sslmode=disable", DriverPostgres, "postgres://u:p@localhost:5432/db?sslmode=disable"},
+		{"postgresql://localhost/db", DriverPostgres, "postgresql://localhost/db"},
+		{"host=localhost user=postgres dbname=db", DriverPostgres, "host=localhost user=postgres dbname=db"},
+		{"sqlite://dev.db", DriverSQLite, "dev.db"},
+		{"sqlite:///tmp/dev.db", DriverSQLite, "/tmp/dev.db"},
+		{"sqlite::memory:", DriverSQLite, ":memory:"},
+	}
+	for _, tc := range cases {
+		driver, driverDSN, err := Parse(tc.dsn)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tc.dsn, err)
+		}
+		if driver != tc.driver || driverDSN != tc.driverDSN {
+			t.Fatalf("Parse(%q) = %q, %q; want %q, %q", tc.dsn, driver, driverDSN, tc.driver, tc.driverDSN)
+		}
+	}
+
+	for _, dsn := range []string{"", "mysql://localhost/db", "sqlite://"} {
+		if _, _, err := Parse(dsn); err == nil {
+			t.Fatalf("expected Parse(%q) to fail", dsn)
+		}
+	}
+}
+
+func TestPoolConfigFromEnv(t *testing.T) {
+	t.Setenv("DB_MAX_OPEN_CONNS", "50")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "1h")
+	t.Setenv("POSTGRES_MAX_OPEN_CONNS", "40")
+	t.Setenv("POSTGRES_MAX_IDLE_CONNS", "not-a-number")
+
+	pg := PoolConfigFromEnv(DriverPostgres)
+	if pg.MaxOpenConns != 40 {
+		t.Fatalf("expected driver-prefixed override 40, got %d", pg.MaxOpenConns)
+	}
+	if pg.MaxIdleConns != defaultPools[DriverPostgres].MaxIdleConns {
+		t.Fatalf("expected invalid value to keep default, got %d", pg.MaxIdleConns)
+	}
+	if pg.ConnMaxLifetime != time.Hour {
+		t.Fatalf("expected DB_ override 1h, got %v", pg.ConnMaxLifetime)
+	}
+
+	lite := PoolConfigFromEnv(DriverSQLite)
+	if lite.MaxOpenConns != 50 || lite.MaxIdleConns != 1 {
+		t.Fatalf("unexpected sqlite pool config: %+v", lite)
+	}
+}
+
+func TestOpenSQLiteMemory(t *testing.T) {
+	db, err := Open("sqlite::memory:", &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	if db.Dialector.Name() != DriverSQLite {
+		t.Fatalf("expected sqlite dialector, got %s", db.Dialector.Name())
+	}
+	sqlDB, _ := db.DB()
+	if got := sqlDB.Stats().MaxOpenConnections; got != 1 {
+		t.Fatalf("expected 1 max open connection, got %d", got)
+	}
+}
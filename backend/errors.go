@@ -1,29 +1,119 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Centralized API error codes used in JSON responses.
 const (
-	CodeInternalError    = "INTERNAL_ERROR"
-	CodeProductNotFound  = "PRODUCT_NOT_FOUND"
-	CodeProductsNotFound = "PRODUCTS_NOT_FOUND"
-	CodeInvalidRequest   = "INVALID_REQUEST"
-	CodeInvalidID        = "INVALID_ID"
-	CodePerPageTooLarge  = "PER_PAGE_TOO_LARGE"
+	CodeInternalError           = "INTERNAL_ERROR"
+	CodeProductNotFound         = "PRODUCT_NOT_FOUND"
+	CodeProductsNotFound        = "PRODUCTS_NOT_FOUND"
+	CodeInvalidRequest          = "INVALID_REQUEST"
+	CodeInvalidID               = "INVALID_ID"
+	CodePerPageTooLarge         = "PER_PAGE_TOO_LARGE"
+	CodeInvalidFilter           = "INVALID_FILTER"
+	CodeInvalidCursor           = "INVALID_CURSOR"
+	CodeReadOnlyField           = "READ_ONLY_FIELD"
+	CodePatchFailed             = "PATCH_FAILED"
+	CodeUnsupportedMedia        = "UNSUPPORTED_MEDIA_TYPE"
+	CodePreconditionFailed      = "PRECONDITION_FAILED"
+	CodePreconditionRequired    = "PRECONDITION_REQUIRED"
+	CodeIdempotencyKeyReused    = "IDEMPOTENCY_KEY_REUSED"
+	CodeIdempotencyInProgress   = "IDEMPOTENCY_REQUEST_IN_PROGRESS"
+	CodeProductCodeConflict     = "PRODUCT_CODE_CONFLICT"
+	CodeAdminRequired           = "ADMIN_REQUIRED"
+	CodeBulkTooLarge            = "BULK_TOO_LARGE"
+	CodeBulkRolledBack          = "BULK_ROLLED_BACK"
+	CodeNotAcceptable           = "NOT_ACCEPTABLE"
+	CodeValidationFailed        = "VALIDATION_FAILED"
+	CodeBulkOperationNotApplied = "BULK_OPERATION_NOT_APPLIED"
+	CodeShuttingDown            = "SHUTTING_DOWN"
+	CodeNotReady                = "NOT_READY"
+	CodeImportIncomplete        = "IMPORT_INCOMPLETE"
 )
 
-// ErrorMessages maps error codes to default human-readable messages.
-var ErrorMessages = map[string]string{
-	CodeInternalError:    "internal server error",
-	CodeProductNotFound:  "product not found",
-	CodeProductsNotFound: "products not found",
-	CodeInvalidRequest:   "invalid request",
-	CodeInvalidID:        "invalid product id",
-	CodePerPageTooLarge:  "per_page exceeds maximum allowed",
+// errorDocsURL is where every error code has a section, anchored by the
+// lowercased code (see docs/errors.md).
+const errorDocsURL = "https://github.com/tanjunior/may/blob/main/docs/errors.md"
+
+// ErrorCode describes an error code: the HTTP status it is always sent
+// with, its default English message, and whether the same request may
+// succeed when retried later.
+type ErrorCode struct {
+	Code      string `json:"code"`
+	Status    int    `json:"status"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	DocURL    string `json:"doc_url"`
+}
+
+// ErrorRegistry declares every error code the API sends. Add new codes
+// here, to docs/errors.md and, when translated, to locales/*.json.
+var ErrorRegistry = registerErrorCodes(map[string]ErrorCode{
+	CodeInternalError:           {Status: http.StatusInternalServerError, Message: "internal server error", Retryable: true},
+	CodeProductNotFound:         {Status: http.StatusNotFound, Message: "product not found"},
+	CodeProductsNotFound:        {Status: http.StatusNotFound, Message: "products not found"},
+	CodeInvalidRequest:          {Status: http.StatusBadRequest, Message: "invalid request"},
+	CodeInvalidID:               {Status: http.StatusBadRequest, Message: "invalid product id"},
+	CodePerPageTooLarge:         {Status: http.StatusBadRequest, Message: "per_page exceeds maximum allowed"},
+	CodeInvalidFilter:           {Status: http.StatusBadRequest, Message: "invalid filter or sort parameter"},
+	CodeInvalidCursor:           {Status: http.StatusBadRequest, Message: "invalid pagination cursor"},
+	CodeReadOnlyField:           {Status: http.StatusBadRequest, Message: "field is read-only"},
+	CodePatchFailed:             {Status: http.StatusUnprocessableEntity, Message: "patch could not be applied"},
+	CodeUnsupportedMedia:        {Status: http.StatusUnsupportedMediaType, Message: "unsupported content type"},
+	CodePreconditionFailed:      {Status: http.StatusPreconditionFailed, Message: "product was modified by another request"},
+	CodePreconditionRequired:    {Status: http.StatusPreconditionRequired, Message: "If-Match header is required"},
+	CodeIdempotencyKeyReused:    {Status: http.StatusConflict, Message: "Idempotency-Key was already used for a different request"},
+	CodeIdempotencyInProgress:   {Status: http.StatusConflict, Message: "a request with this Idempotency-Key is still being processed", Retryable: true},
+	CodeProductCodeConflict:     {Status: http.StatusConflict, Message: "another product already uses this code"},
+	CodeAdminRequired:           {Status: http.StatusForbidden, Message: "admin privileges required"},
+	CodeBulkTooLarge:            {Status: http.StatusBadRequest, Message: "too many operations in one batch"},
+	CodeBulkRolledBack:          {Status: http.StatusUnprocessableEntity, Message: "batch rolled back because an operation failed"},
+	CodeBulkOperationNotApplied: {Status: http.StatusFailedDependency, Message: "operation not applied because another operation in the batch failed"},
+	CodeNotAcceptable:           {Status: http.StatusNotAcceptable, Message: "none of the accepted media types can be produced"},
+	CodeValidationFailed:        {Status: http.StatusBadRequest, Message: "one or more fields are invalid"},
+	CodeShuttingDown:            {Status: http.StatusServiceUnavailable, Message: "server is shutting down", Retryable: true},
+	CodeNotReady:                {Status: http.StatusServiceUnavailable, Message: "a required dependency is unavailable", Retryable: true},
+	CodeImportIncomplete:        {Status: http.StatusInternalServerError, Message: "import stopped partway; earlier rows were saved", Retryable: true},
+})
+
+// registerErrorCodes fills in the Code and DocURL of every entry.
+func registerErrorCodes(codes map[string]ErrorCode) map[string]ErrorCode {
+	for code, ec := range codes {
+		ec.Code = code
+		ec.DocURL = errorDocsURL + "#" + strings.ToLower(code)
+		codes[code] = ec
+	}
+	return codes
+}
+
+// ErrorMessages maps error codes to default human-readable messages, in
+// English. Translations live in locales/<language>.json.
+var ErrorMessages = func() map[string]string {
+	messages := make(map[string]string, len(ErrorRegistry))
+	for code, ec := range ErrorRegistry {
+		messages[code] = ec.Message
+	}
+	return messages
+}()
+
+// lookupErrorCode returns the registry entry for code. Unregistered codes
+// (which the registry test rules out) are sent as internal errors.
+func lookupErrorCode(code string) ErrorCode {
+	if ec, ok := ErrorRegistry[code]; ok {
+		return ec
+	}
+	return ErrorCode{Code: code, Status: http.StatusInternalServerError, DocURL: "about:blank"}
 }
 
 // APIError represents a structured API error.
@@ -33,48 +123,134 @@ type APIError struct {
 	Details interface{} `json:"details,omitempty"`
 }
 
-// NewAPIError builds an APIError from a code and optional details.
-func NewAPIError(code string, details interface{}) APIError {
-	msg := ErrorMessages[code]
-	return APIError{Code: code, Message: msg, Details: details}
+// NewAPIError builds an APIError from a code and optional details, with
+// the message in the language negotiated for ctx (see negotiateLanguage).
+func NewAPIError(ctx context.Context, code string, details interface{}) APIError {
+	return APIError{Code: code, Message: localizedMessage(ctx, code), Details: details}
 }
 
-// respondAPIError sends an APIError using the existing respondError envelope.
-func respondAPIError(c *gin.Context, status int, apiErr APIError) {
-	respondError(c, status, apiErr.Code, apiErr.Message, apiErr.Details)
+// mediaTypeProblem is the RFC 9457 problem details media type.
+const mediaTypeProblem = "application/problem+json"
+
+// problemFormatKey is the gin context key set when errors should be sent
+// as problem details by default.
+const problemFormatKey = "problemFormat"
+
+// problemDetails is an RFC 9457 problem document. Code, Details and
+// RequestID are extension members carrying the same values as the
+// envelope.
+type problemDetails struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail,omitempty"`
+	Instance  string      `json:"instance,omitempty"`
+	Code      string      `json:"code"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
 }
 
-// respondErrorCode is a convenience helper: pass only the code and details,
-// the message will be populated from the default messages map.
-func respondErrorCode(c *gin.Context, status int, code string, details interface{}) {
-	respondAPIError(c, status, NewAPIError(code, details))
+// errorFormat makes problem+json the default error format for the
+// requests it handles when problem is set (see `ERROR_FORMAT`).
+func errorFormat(problem bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(problemFormatKey, problem)
+		c.Next()
+	}
+}
+
+// wantsProblem reports whether the error for c should be sent as problem
+// details: the client's Accept header decides between problem+json and
+// the envelope, and the server default breaks ties or applies when it
+// accepts neither.
+func wantsProblem(c *gin.Context) bool {
+	offered := []string{mediaTypeJSON, mediaTypeProblem}
+	if c.GetBool(problemFormatKey) {
+		offered = []string{mediaTypeProblem, mediaTypeJSON}
+	}
+	mediaType, ok := negotiateMediaType(c.GetHeader("Accept"), offered)
+	if !ok {
+		mediaType = offered[0]
+	}
+	return mediaType == mediaTypeProblem
 }
 
-// Typed constructors that return an APIError and associated HTTP status.
-func NewBadRequest(code string, details interface{}) (APIError, int) {
-	return NewAPIError(code, details), http.StatusBadRequest
+// respondProblem sends apiErr as an RFC 9457 problem document. The type is
+// the code's documentation URL and the title the status text, which is
+// fixed per code by the registry.
+func respondProblem(c *gin.Context, status int, apiErr APIError) {
+	c.Header("Content-Type", mediaTypeProblem)
+	c.JSON(status, problemDetails{
+		Type:      lookupErrorCode(apiErr.Code).DocURL,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    apiErr.Message,
+		Instance:  c.Request.URL.RequestURI(),
+		Code:      apiErr.Code,
+		Details:   apiErr.Details,
+		RequestID: requestIDFrom(c.Request.Context()),
+	})
 }
 
-func NewNotFound(code string, details interface{}) (APIError, int) {
-	return NewAPIError(code, details), http.StatusNotFound
+// respondAPIError sends an APIError in the negotiated error format: the
+// respondError envelope by default, or problem+json. The status comes
+// from the registry.
+func respondAPIError(c *gin.Context, apiErr APIError) {
+	status := lookupErrorCode(apiErr.Code).Status
+	if wantsProblem(c) {
+		respondProblem(c, status, apiErr)
+		return
+	}
+	respondError(c, status, apiErr.Code, apiErr.Message, apiErr.Details)
+}
+
+// respondErrorCode is a convenience helper: pass only the code and details,
+// the status and message will be populated from the registry.
+func respondErrorCode(c *gin.Context, code string, details interface{}) {
+	respondAPIError(c, NewAPIError(c.Request.Context(), code, details))
 }
 
-func NewInternalError(code string, details interface{}) (APIError, int) {
-	return NewAPIError(code, details), http.StatusInternalServerError
+// internalErrorDetails logs err and returns the details to send with
+// INTERNAL_ERROR: only a correlation ID (the request ID when ctx has one)
+// to find the log entry, so driver and SQL messages stay server-side, plus
+// the cause outside gin's release mode to ease local development.
+func internalErrorDetails(ctx context.Context, err error) map[string]interface{} {
+	id := requestIDFrom(ctx)
+	if id == "" {
+		id = newRequestID()
+	}
+	slog.ErrorContext(ctx, "internal error", slog.String("correlation_id", id), slog.String("error", err.Error()))
+	details := map[string]interface{}{"correlation_id": id}
+	if gin.Mode() != gin.ReleaseMode {
+		details["cause"] = err.Error()
+	}
+	return details
 }
 
-// Convenience responder wrappers using the typed constructors above.
-func RespondBadRequest(c *gin.Context, code string, details interface{}) {
-	apiErr, status := NewBadRequest(code, details)
-	respondAPIError(c, status, apiErr)
+// newRequestID returns a random 16-character hex ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
 }
 
-func RespondNotFound(c *gin.Context, code string, details interface{}) {
-	apiErr, status := NewNotFound(code, details)
-	respondAPIError(c, status, apiErr)
+// respondInternalError responds INTERNAL_ERROR for err without exposing it
+// (see internalErrorDetails).
+func respondInternalError(c *gin.Context, err error) {
+	details := internalErrorDetails(c.Request.Context(), fmt.Errorf("%s %s: %w", c.Request.Method, c.Request.URL.Path, err))
+	respondErrorCode(c, CodeInternalError, details)
 }
 
-func RespondInternal(c *gin.Context, code string, details interface{}) {
-	apiErr, status := NewInternalError(code, details)
-	respondAPIError(c, status, apiErr)
+// listErrorCodes serves GET /errors: the registry sorted by code, with
+// messages in the negotiated language.
+func listErrorCodes(c *gin.Context) {
+	codes := make([]ErrorCode, 0, len(ErrorRegistry))
+	for _, ec := range ErrorRegistry {
+		ec.Message = localizedMessage(c.Request.Context(), ec.Code)
+		codes = append(codes, ec)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+	respondSuccess(c, http.StatusOK, codes, map[string]interface{}{"total": len(codes)})
 }
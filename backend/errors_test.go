@@ -0,0 +1,116 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// errorCodeArgs gives, for each function that sends an error, the index
+// of its error code argument.
+var errorCodeArgs = map[string]int{
+	"respondErrorCode": 1,
+	"NewAPIError":      1,
+	"fail":             1,
+	"respondError":     2,
+}
+
+// TestErrorRegistry checks that every code a handler can respond with is
+// registered: each Code* constant, and each code argument of the error
+// helpers (constant or literal) in the package sources.
+func TestErrorRegistry(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("failed to parse package: %v", err)
+	}
+	files := pkgs["main"].Files
+
+	// the error code constants
+	consts := map[string]string{}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, name := range vs.Names {
+					if !strings.HasPrefix(name.Name, "Code") || i >= len(vs.Values) {
+						continue
+					}
+					if lit, ok := vs.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						consts[name.Name], _ = strconv.Unquote(lit.Value)
+					}
+				}
+			}
+		}
+	}
+	for name, code := range consts {
+		if _, ok := ErrorRegistry[code]; !ok {
+			t.Errorf("%s (%s) is not in ErrorRegistry", name, code)
+		}
+	}
+
+	check := func(pos token.Pos, expr ast.Expr) {
+		switch arg := expr.(type) {
+		case *ast.Ident:
+			code, ok := consts[arg.Name]
+			if !ok {
+				return // a variable, e.g. a code passed through
+			}
+			if _, ok := ErrorRegistry[code]; !ok {
+				t.Errorf("%s: responds with unregistered code %s", fset.Position(pos), code)
+			}
+		case *ast.BasicLit:
+			code, _ := strconv.Unquote(arg.Value)
+			if _, ok := ErrorRegistry[code]; !ok {
+				t.Errorf("%s: responds with unregistered code %q", fset.Position(pos), code)
+			}
+		}
+	}
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				var name string
+				switch fun := n.Fun.(type) {
+				case *ast.Ident:
+					name = fun.Name
+				case *ast.SelectorExpr:
+					name = fun.Sel.Name
+				}
+				if i, ok := errorCodeArgs[name]; ok && i < len(n.Args) {
+					check(n.Pos(), n.Args[i])
+				}
+			case *ast.CompositeLit:
+				if typ, ok := n.Type.(*ast.Ident); ok && typ.Name == "patchError" && len(n.Elts) > 0 {
+					check(n.Pos(), n.Elts[0])
+				}
+			}
+			return true
+		})
+	}
+}
+
+func TestErrorRegistryEntries(t *testing.T) {
+	docs, err := os.ReadFile("../docs/errors.md")
+	if err != nil {
+		t.Fatalf("failed to read docs/errors.md: %v", err)
+	}
+	for code, ec := range ErrorRegistry {
+		if ec.Code != code || ec.Status < 400 || ec.Message == "" {
+			t.Errorf("incomplete registry entry %+v", ec)
+		}
+		if !strings.Contains(string(docs), "\n### "+code+"\n") {
+			t.Errorf("docs/errors.md has no section for %s", code)
+		}
+	}
+}
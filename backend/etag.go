@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// productETag returns the strong entity tag of p's current version in the
+// JSON envelope, e.g. `"12-3"` for version 3 of product 12. The ID keeps
+// tags of different products apart, so routes like /product/latest can't
+// answer 304 for a product the client hasn't seen.
+func productETag(p Product) string {
+	return representationETag(p, mediaTypeJSON)
+}
+
+// representationETag returns the strong entity tag of p in mediaType. The
+// representations of a version differ byte for byte, so each but the JSON
+// envelope gets its own tag, suffixed with its format (e.g. `"12-3-csv"`).
+func representationETag(p Product, mediaType string) string {
+	if mediaType == mediaTypeJSON {
+		return fmt.Sprintf(`"%d-%d"`, p.ID, p.Version)
+	}
+	_, format, _ := strings.Cut(mediaType, "/")
+	return fmt.Sprintf(`"%d-%d-%s"`, p.ID, p.Version, strings.TrimPrefix(format, "x-"))
+}
+
+// productETagMatches reports whether an If-Match header names the current
+// version of p in any of its representations, so a client may send back
+// the tag of whichever format it read.
+func productETagMatches(header string, p Product) bool {
+	for _, mediaType := range productMediaTypes {
+		if etagMatches(header, representationETag(p, mediaType), false) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether an If-Match/If-None-Match header value (a
+// comma-separated list of entity tags, or "*") matches etag. If-Match uses
+// strong comparison, so weak tags only match when weak is set.
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// loadForWrite fetches the product a PUT/PATCH/DELETE targets and checks
+// its If-Match precondition. When requireIfMatch is set the header is
+// mandatory. On failure it responds and returns false.
+func loadForWrite(c *gin.Context, repo ProductRepository, id uint, requireIfMatch bool) (Product, bool) {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" && requireIfMatch {
+		respondErrorCode(c, CodePreconditionRequired, nil)
+		return Product{}, false
+	}
+
+	product, err := repo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondErrorCode(c, CodeProductNotFound, nil)
+			return Product{}, false
+		}
+		respondInternalError(c, err)
+		return Product{}, false
+	}
+
+	if ifMatch != "" && !productETagMatches(ifMatch, product) {
+		respondPreconditionFailed(c, product)
+		return Product{}, false
+	}
+	return product, true
+}
+
+// respondPreconditionFailed reports a stale If-Match (or a concurrent
+// write) with the product's current entity tag.
+func respondPreconditionFailed(c *gin.Context, current Product) {
+	c.Header("ETag", productETag(current))
+	respondErrorCode(c, CodePreconditionFailed, map[string]interface{}{"current_etag": productETag(current)})
+}
+
+// respondProduct sends a single product with the ETag of its negotiated
+// representation, or 304 Not Modified when a GET's If-None-Match already
+// names it. The body uses the representation negotiated for the route, if
+// any.
+func respondProduct(c *gin.Context, status int, product Product) {
+	mediaType := negotiated(c)
+	etag := representationETag(product, mediaType)
+	c.Header("ETag", etag)
+	if c.Request.Method == http.MethodGet {
+		if inm := c.GetHeader("If-None-Match"); inm != "" && etagMatches(inm, etag, true) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	if mediaType != mediaTypeJSON {
+		renderProducts(c, status, mediaType, []Product{product}, true)
+		return
+	}
+	respondSuccess(c, status, product, nil)
+}
+
+// respondVersionConflict reports an ErrVersionConflict from the repository,
+// i.e. a write that lost a race with another request after its If-Match
+// check passed.
+func respondVersionConflict(c *gin.Context, repo ProductRepository, id uint) {
+	current, err := repo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		respondErrorCode(c, CodePreconditionFailed, nil)
+		return
+	}
+	respondPreconditionFailed(c, current)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportFlushEvery is how many rows are written between flushes of the
+// response, so clients receive the export as a steady chunked stream.
+const exportFlushEvery = 200
+
+// exportColumns are the columns of CSV and XLSX exports. code and price
+// match what POST /products/import reads, so exports can be re-imported.
+var exportColumns = []string{"id", "code", "price", "version", "created_at", "updated_at"}
+
+// productRecord is the flat form of a product used by every format other
+// than the JSON envelope.
+type productRecord struct {
+	XMLName   xml.Name  `json:"-" codec:"-" xml:"product"`
+	ID        uint      `json:"id" xml:"id"`
+	Code      string    `json:"code" xml:"code"`
+	Price     uint      `json:"price" xml:"price"`
+	Version   uint      `json:"version" xml:"version"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+func newProductRecord(p Product) productRecord {
+	return productRecord{ID: p.ID, Code: p.Code, Price: p.Price, Version: p.Version, CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt}
+}
+
+// productExporter encodes products in one export format.
+type productExporter interface {
+	Write(p Product) error
+	// Flush pushes buffered rows to the underlying writer.
+	Flush() error
+	// Close writes any trailing data.
+	Close() error
+}
+
+// exportFormats maps the `format` parameter to its content type, file
+// extension and exporter.
+var exportFormats = map[string]struct {
+	contentType string
+	newExporter func(w io.Writer) (productExporter, error)
+}{
+	"csv":    {"text/csv; charset=utf-8", newCSVExporter},
+	"ndjson": {mediaTypeNDJSON, newNDJSONExporter},
+	"xlsx":   {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", newXLSXExporter},
+}
+
+type csvExporter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVExporter(w io.Writer) (productExporter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return nil, err
+	}
+	return &csvExporter{w: cw, record: make([]string, len(exportColumns))}, nil
+}
+
+func (e *csvExporter) Write(p Product) error {
+	e.record[0] = strconv.FormatUint(uint64(p.ID), 10)
+	e.record[1] = p.Code
+	e.record[2] = strconv.FormatUint(uint64(p.Price), 10)
+	e.record[3] = strconv.FormatUint(uint64(p.Version), 10)
+	e.record[4] = p.CreatedAt.UTC().Format(time.RFC3339)
+	e.record[5] = p.UpdatedAt.UTC().Format(time.RFC3339)
+	return e.w.Write(e.record)
+}
+
+func (e *csvExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExporter) Close() error { return e.Flush() }
+
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONExporter(w io.Writer) (productExporter, error) {
+	return &ndjsonExporter{enc: json.NewEncoder(w)}, nil
+}
+
+func (e *ndjsonExporter) Write(p Product) error {
+	return e.enc.Encode(newProductRecord(p))
+}
+
+func (e *ndjsonExporter) Flush() error { return nil }
+func (e *ndjsonExporter) Close() error { return nil }
+
+// xlsxParts are the fixed parts of an XLSX package holding one sheet,
+// xl/worksheets/sheet1.xml, which xlsxExporter streams.
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// xlsxExporter writes an XLSX file (a zip archive) straight to the
+// response: the fixed parts first, then the sheet row by row with inline
+// strings, so only the compressor's window is held in memory. Flush
+// pushes the compressed rows out as the other formats do.
+type xlsxExporter struct {
+	zw    *zip.Writer
+	fw    *flate.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+func newXLSXExporter(w io.Writer) (productExporter, error) {
+	e := &xlsxExporter{zw: zip.NewWriter(w)}
+	e.zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		fw, err := flate.NewWriter(out, flate.DefaultCompression)
+		e.fw = fw
+		return fw, err
+	})
+	for _, part := range xlsxParts {
+		pw, err := e.zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(pw, part.body); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := e.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	e.sheet = bufio.NewWriter(sheet)
+	if _, err := e.sheet.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, err
+	}
+
+	header := make([]interface{}, len(exportColumns))
+	for i, col := range exportColumns {
+		header[i] = col
+	}
+	return e, e.writeRow(header)
+}
+
+// writeRow appends a row of numbers and inline strings to the sheet. It
+// stops at the first write error, so a broken response ends the export.
+func (e *xlsxExporter) writeRow(values []interface{}) error {
+	e.row++
+	if _, err := fmt.Fprintf(e.sheet, `<row r="%d">`, e.row); err != nil {
+		return err
+	}
+	for i, v := range values {
+		cell := xlsxColumnName(i+1) + strconv.Itoa(e.row)
+		var err error
+		switch v := v.(type) {
+		case uint:
+			_, err = fmt.Fprintf(e.sheet, `<c r="%s"><v>%d</v></c>`, cell, v)
+		default:
+			if _, err = fmt.Fprintf(e.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, cell); err != nil {
+				break
+			}
+			if err = xml.EscapeText(e.sheet, []byte(fmt.Sprint(v))); err != nil {
+				break
+			}
+			_, err = e.sheet.WriteString(`</t></is></c>`)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := e.sheet.WriteString(`</row>`)
+	return err
+}
+
+// xlsxColumnName returns the letters naming the 1-based column n: A to Z,
+// then AA, AB and so on.
+func xlsxColumnName(n int) string {
+	var name []byte
+	for ; n > 0; n = (n - 1) / 26 {
+		name = append([]byte{byte('A' + (n-1)%26)}, name...)
+	}
+	return string(name)
+}
+
+func (e *xlsxExporter) Write(p Product) error {
+	return e.writeRow([]interface{}{
+		p.ID, p.Code, p.Price, p.Version,
+		p.CreatedAt.UTC().Format(time.RFC3339), p.UpdatedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+func (e *xlsxExporter) Flush() error {
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	if err := e.fw.Flush(); err != nil {
+		return err
+	}
+	return e.zw.Flush()
+}
+
+func (e *xlsxExporter) Close() error {
+	if _, err := e.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zw.Close()
+}
+
+// exportProducts serves GET /products/export?format=csv|ndjson|xlsx. Every
+// product matching the list filters is read in keyset pages (see
+// ProductRepository.Each) and streamed straight into the response, so
+// memory use doesn't grow with the catalog and the database isn't tied up
+// by slow downloads. Errors after the first bytes are sent can only be
+// logged.
+func exportProducts(c *gin.Context, repo ProductRepository) {
+	query, qerr := parseProductQuery(c.Request.URL.Query())
+	if qerr != nil {
+		respondErrorCode(c, CodeInvalidFilter, qerr.Details())
+		return
+	}
+
+	name := c.DefaultQuery("format", "csv")
+	format, ok := exportFormats[name]
+	if !ok {
+		respondErrorCode(c, CodeInvalidRequest, map[string]interface{}{
+			"format":  name,
+			"allowed": []string{"csv", "ndjson", "xlsx"},
+		})
+		return
+	}
+
+	exporter, err := format.newExporter(c.Writer)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	c.Header("Content-Type", format.contentType)
+	c.Header("Content-Disposition", `attachment; filename="products.`+name+`"`)
+	c.Status(http.StatusOK)
+
+	n := 0
+	err = repo.Each(c.Request.Context(), query, func(p Product) error {
+		if err := exporter.Write(p); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlushEvery == 0 {
+			if err := exporter.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = exporter.Close()
+	}
+	if err != nil {
+		abortProductStream(c, "export: aborted", n, err)
+		return
+	}
+	c.Writer.Flush()
+}
+
+// abortProductStream handles err from a streamed product response after n
+// products were encoded: while nothing has reached the client yet it
+// becomes a 500 in place of the stream's headers, afterwards the response
+// is cut short and the error can only be logged, as msg.
+func abortProductStream(c *gin.Context, msg string, n int, err error) {
+	if !c.Writer.Written() {
+		for _, h := range []string{"Content-Type", "Content-Disposition", "ETag"} {
+			c.Writer.Header().Del(h)
+		}
+		respondInternalError(c, err)
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), msg, slog.Int("products", n), slog.String("error", err.Error()))
+	c.Abort()
+}
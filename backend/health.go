@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"may/migrate"
+	"may/migrations"
+)
+
+// HealthCheck is a readiness check of one dependency. Check returns
+// details to report (may be nil) and an error when the dependency is
+// unusable; a failing Critical check makes GET /readyz fail.
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) (map[string]interface{}, error)
+}
+
+// checkResult is the outcome of one HealthCheck in the readiness report.
+type checkResult struct {
+	Name       string                 `json:"name"`
+	Status     string                 `json:"status"`
+	Critical   bool                   `json:"critical"`
+	DurationMS float64                `json:"duration_ms"`
+	Error      string                 `json:"error,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// healthReport is the body of GET /readyz, or the error details when it
+// fails.
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+// runHealthChecks runs checks concurrently, each bounded by timeout, and
+// reports them in registration order. Check errors are logged; like
+// internal errors they are only included in the report outside gin's
+// release mode.
+func runHealthChecks(ctx context.Context, checks []HealthCheck, timeout time.Duration) healthReport {
+	report := healthReport{Status: "ready", Checks: make([]checkResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := check.Check(checkCtx)
+			res := checkResult{
+				Name:       check.Name,
+				Status:     "pass",
+				Critical:   check.Critical,
+				DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+				Details:    details,
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "health check failed", slog.String("check", check.Name), slog.String("error", err.Error()))
+				res.Status = "fail"
+				if gin.Mode() != gin.ReleaseMode {
+					res.Error = err.Error()
+				}
+			}
+			report.Checks[i] = res
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, res := range report.Checks {
+		if res.Critical && res.Status == "fail" {
+			report.Status = "not_ready"
+		}
+	}
+	return report
+}
+
+// readyz serves GET /readyz: 200 with the report when every critical
+// check passes, otherwise 503 NOT_READY with the report in details (or
+// SHUTTING_DOWN once shutdown has begun).
+func readyz(c *gin.Context, checks []HealthCheck, timeout time.Duration, shutdown *shutdownState) {
+	if shutdown.Draining() {
+		respondErrorCode(c, CodeShuttingDown, nil)
+		return
+	}
+	report := runHealthChecks(c.Request.Context(), checks, timeout)
+	if report.Status != "ready" {
+		respondErrorCode(c, CodeNotReady, report)
+		return
+	}
+	respondSuccess(c, http.StatusOK, report, nil)
+}
+
+// databaseCheck pings the database and reports its connection pool.
+func databaseCheck(db *gorm.DB) HealthCheck {
+	return HealthCheck{Name: "database", Critical: true, Check: func(ctx context.Context) (map[string]interface{}, error) {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		stats := sqlDB.Stats()
+		details := map[string]interface{}{
+			"driver":               db.Dialector.Name(),
+			"max_open_connections": stats.MaxOpenConnections,
+			"open_connections":     stats.OpenConnections,
+			"in_use":               stats.InUse,
+			"idle":                 stats.Idle,
+			"wait_count":           stats.WaitCount,
+			"wait_duration_ms":     stats.WaitDuration.Milliseconds(),
+		}
+		return details, sqlDB.PingContext(ctx)
+	}}
+}
+
+// migrationsCheck reports the embedded migrations applied to the
+// database, failing while any is pending since the schema may not match
+// the code. It only reads schema_migrations, so probes never run DDL; a
+// missing table counts every migration as pending.
+func migrationsCheck(db *gorm.DB) HealthCheck {
+	return HealthCheck{Name: "migrations", Critical: true, Check: func(ctx context.Context) (map[string]interface{}, error) {
+		statuses, err := migrate.ReadStatus(ctx, db, migrations.FS)
+		if err != nil {
+			return nil, err
+		}
+		var applied, pending int
+		var current int64
+		for _, s := range statuses {
+			if s.Applied {
+				applied++
+				current = s.Version
+			} else {
+				pending++
+			}
+		}
+		details := map[string]interface{}{"applied": applied, "pending": pending, "current_version": current}
+		if pending > 0 {
+			return details, fmt.Errorf("%d pending migrations", pending)
+		}
+		return details, nil
+	}}
+}
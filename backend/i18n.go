@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"may/locales"
+)
+
+// defaultLanguage is the language of ErrorMessages, the last link of
+// every fallback chain.
+const defaultLanguage = "en"
+
+// errorCatalogs maps a lowercase language tag to its translated error
+// messages, loaded from the embedded locales/*.json files.
+var errorCatalogs = mustLoadCatalogs(locales.FS)
+
+// mustLoadCatalogs reads every `<language>.json` catalog in fsys. A
+// malformed catalog is a build mistake, so it panics like
+// regexp.MustCompile.
+func mustLoadCatalogs(fsys fs.FS) map[string]map[string]string {
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[string]map[string]string, len(names))
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic("locales/" + name + ": " + err.Error())
+		}
+		catalogs[strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))] = messages
+	}
+	return catalogs
+}
+
+// languageRange is one entry of an Accept-Language header.
+type languageRange struct {
+	tag string
+	q   float64
+}
+
+// languageChain turns an Accept-Language header into the languages to try
+// for a message, most preferred first: each tag is followed by its primary
+// language (`pt-BR` then `pt`), and defaultLanguage closes the chain.
+// Wildcards and q=0 ranges are skipped.
+func languageChain(header string) []string {
+	var ranges []languageRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(params[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		r := languageRange{tag: tag, q: 1}
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q >= 0 && q <= 1 {
+					r.q = q
+				}
+			}
+		}
+		if r.q > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	var chain []string
+	seen := map[string]bool{}
+	add := func(tag string) {
+		if !seen[tag] {
+			seen[tag] = true
+			chain = append(chain, tag)
+		}
+	}
+	for _, r := range ranges {
+		add(r.tag)
+		if primary, _, ok := strings.Cut(r.tag, "-"); ok {
+			add(primary)
+		}
+	}
+	add(defaultLanguage)
+	return chain
+}
+
+// languagesKey is the request context key holding the language chain.
+type languagesKey struct{}
+
+// negotiateLanguage stores the language chain of the request's
+// Accept-Language header in its context, for NewAPIError to pick messages
+// from.
+func negotiateLanguage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chain := languageChain(c.GetHeader("Accept-Language"))
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), languagesKey{}, chain))
+		c.Next()
+	}
+}
+
+// localizedMessage returns the message for code in the first language of
+// the context's chain that translates it, or the English default.
+func localizedMessage(ctx context.Context, code string) string {
+	chain, _ := ctx.Value(languagesKey{}).([]string)
+	for _, lang := range chain {
+		if lang == defaultLanguage {
+			break
+		}
+		if msg, ok := errorCatalogs[lang][code]; ok {
+			return msg
+		}
+	}
+	return ErrorMessages[code]
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLanguageChain(t *testing.T) {
+	cases := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{"en"}},
+		{"*", []string{"en"}},
+		{"th", []string{"th", "en"}},
+		{"es-MX, en;q=0.5", []string{"es-mx", "es", "en"}},
+		{"fr;q=0.2, TH-th;q=0.8", []string{"th-th", "th", "fr", "en"}},
+		{"de;q=0, es", []string{"es", "en"}},
+	}
+	for _, tc := range cases {
+		if got := languageChain(tc.header); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("languageChain(%q) = %v; want %v", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestErrorCatalogs(t *testing.T) {
+	if len(errorCatalogs) == 0 {
+		t.Fatal("no message catalogs loaded")
+	}
+	for lang, messages := range errorCatalogs {
+		for code, msg := range messages {
+			if _, ok := ErrorMessages[code]; !ok {
+				t.Errorf("locales/%s.json: unknown error code %s", lang, code)
+			}
+			if msg == "" {
+				t.Errorf("locales/%s.json: empty message for %s", lang, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyRecord is a stored Idempotency-Key with the fingerprint of
+// the request that first used it and, once that request has finished,
+// the response to replay. StatusCode is 0 while the request is in flight.
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	StatusCode  int
+	Headers     map[string]string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore persists Idempotency-Key records.
+type IdempotencyStore interface {
+	// Reserve claims rec.Key for a new request. If an unexpired record
+	// already holds the key, it is returned with reserved=false.
+	Reserve(ctx context.Context, rec IdempotencyRecord) (existing IdempotencyRecord, reserved bool, err error)
+	// Complete stores the response for a reserved key.
+	Complete(ctx context.Context, key string, status int, headers map[string]string, body []byte) error
+	// Release forgets a reserved key so the request can be retried.
+	Release(ctx context.Context, key string) error
+	// DeleteExpired removes records that expired before now.
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// idempotencyReplayHeaders are the response headers stored for replay.
+var idempotencyReplayHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// bodyRecorder captures a response body while passing it through.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyFingerprint identifies a request by method, path and body so
+// a key reused for a different request can be detected.
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotent honours the `Idempotency-Key` request header: the first
+// request with a key runs normally and its response is stored for ttl;
+// repeats with the same body get that response replayed (marked with
+// `Idempotent-Replayed: true`), and repeats with a different body, or
+// while the first is still running, get 409. Server errors are not stored
+// so the client can retry them.
+func idempotent(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondErrorCode(c, CodeInvalidRequest, map[string]interface{}{"header": "Idempotency-Key", "max_length": maxIdempotencyKeyLength})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondErrorCode(c, CodeInvalidRequest, err.Error())
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		now := time.Now()
+		rec := IdempotencyRecord{
+			Key:         key,
+			Fingerprint: idempotencyFingerprint(c.Request, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+		}
+		existing, reserved, err := store.Reserve(ctx, rec)
+		if err != nil {
+			respondInternalError(c, err)
+			c.Abort()
+			return
+		}
+
+		if !reserved {
+			switch {
+			case existing.Fingerprint != rec.Fingerprint:
+				respondErrorCode(c, CodeIdempotencyKeyReused, map[string]interface{}{"key": key})
+			case existing.StatusCode == 0:
+				respondErrorCode(c, CodeIdempotencyInProgress, map[string]interface{}{"key": key})
+			default:
+				for k, v := range existing.Headers {
+					c.Header(k, v)
+				}
+				c.Header("Idempotent-Replayed", "true")
+				c.Status(existing.StatusCode)
+				c.Writer.Write(existing.Body)
+			}
+			c.Abort()
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		// Settle the key even if the handler panics: recoverPanics sits
+		// outside this middleware, so the panic is treated as a server error
+		// here, the key released, and the panic passed on for it to answer.
+		defer func() {
+			recovered := recover()
+			status := recorder.Status()
+			if recovered != nil {
+				status = http.StatusInternalServerError
+			}
+			settleIdempotencyKey(c.Request.Context(), store, key, status, recorder)
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// settleIdempotencyKey stores the response recorded for key, or releases
+// the key when status is a server error so the request can be retried.
+func settleIdempotencyKey(ctx context.Context, store IdempotencyStore, key string, status int, recorder *bodyRecorder) {
+	// Detach from the request's cancellation (it may already be canceled,
+	// and the key must not stay reserved forever) but keep its values,
+	// such as the request ID.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if status >= http.StatusInternalServerError {
+		if err := store.Release(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "idempotency: failed to release key", slog.String("key", key), slog.String("error", err.Error()))
+		}
+		return
+	}
+	headers := map[string]string{}
+	for _, h := range idempotencyReplayHeaders {
+		if v := recorder.Header().Get(h); v != "" {
+			headers[h] = v
+		}
+	}
+	if err := store.Complete(ctx, key, status, headers, recorder.body.Bytes()); err != nil {
+		slog.ErrorContext(ctx, "idempotency: failed to store response", slog.String("key", key), slog.String("error", err.Error()))
+	}
+}
+
+// purgeIdempotencyKeys deletes expired idempotency records every interval
+// until ctx is done.
+func purgeIdempotencyKeys(ctx context.Context, store IdempotencyStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n, err := store.DeleteExpired(ctx, now); err != nil {
+				slog.ErrorContext(ctx, "idempotency: purge failed", slog.String("error", err.Error()))
+			} else if n > 0 {
+				slog.InfoContext(ctx, "idempotency: purged expired keys", slog.Int64("count", n))
+			}
+		}
+	}
+}
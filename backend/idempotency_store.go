@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// idempotencyRow is the idempotency_keys table row.
+type idempotencyRow struct {
+	Key             string `gorm:"column:idempotency_key;primaryKey"`
+	Fingerprint     string
+	StatusCode      int
+	ResponseHeaders string
+	ResponseBody    string
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+}
+
+func (idempotencyRow) TableName() string { return "idempotency_keys" }
+
+func (row idempotencyRow) record() IdempotencyRecord {
+	rec := IdempotencyRecord{
+		Key:         row.Key,
+		Fingerprint: row.Fingerprint,
+		StatusCode:  row.StatusCode,
+		Body:        []byte(row.ResponseBody),
+		CreatedAt:   row.CreatedAt,
+		ExpiresAt:   row.ExpiresAt,
+	}
+	if row.ResponseHeaders != "" {
+		_ = json.Unmarshal([]byte(row.ResponseHeaders), &rec.Headers)
+	}
+	return rec
+}
+
+// gormIdempotencyStore is the database-backed IdempotencyStore, shared by
+// every server instance using the same database.
+type gormIdempotencyStore struct {
+	db *gorm.DB
+}
+
+// NewGormIdempotencyStore returns an IdempotencyStore backed by db.
+func NewGormIdempotencyStore(db *gorm.DB) IdempotencyStore {
+	return &gormIdempotencyStore{db: db}
+}
+
+func (s *gormIdempotencyStore) Reserve(ctx context.Context, rec IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	db := s.db.WithContext(ctx)
+
+	// an expired record no longer holds its key
+	if err := db.Where("idempotency_key = ? AND expires_at <= ?", rec.Key, rec.CreatedAt).Delete(&idempotencyRow{}).Error; err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	row := idempotencyRow{Key: rec.Key, Fingerprint: rec.Fingerprint, CreatedAt: rec.CreatedAt, ExpiresAt: rec.ExpiresAt}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if result.Error != nil {
+		return IdempotencyRecord{}, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return IdempotencyRecord{}, true, nil
+	}
+
+	var existing idempotencyRow
+	if err := db.Where("idempotency_key = ?", rec.Key).Take(&existing).Error; err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return existing.record(), false, nil
+}
+
+func (s *gormIdempotencyStore) Complete(ctx context.Context, key string, status int, headers map[string]string, body []byte) error {
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Model(&idempotencyRow{}).
+		Where("idempotency_key = ?", key).
+		Updates(map[string]interface{}{
+			"status_code":      status,
+			"response_headers": string(encoded),
+			"response_body":    string(body),
+		}).Error
+}
+
+func (s *gormIdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("idempotency_key = ?", key).Delete(&idempotencyRow{}).Error
+}
+
+func (s *gormIdempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&idempotencyRow{})
+	return result.RowsAffected, result.Error
+}
+
+// memoryIdempotencyStore is an in-process IdempotencyStore for tests and
+// single-instance deployments.
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+// NewMemoryIdempotencyStore returns an empty in-memory IdempotencyStore.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}}
+}
+
+func (s *memoryIdempotencyStore) Reserve(ctx context.Context, rec IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[rec.Key]; ok && existing.ExpiresAt.After(rec.CreatedAt) {
+		return existing, false, nil
+	}
+	s.records[rec.Key] = rec
+	return IdempotencyRecord{}, true, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, key string, status int, headers map[string]string, body []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[key]
+	if !ok {
+		return nil
+	}
+	rec.StatusCode = status
+	rec.Headers = headers
+	rec.Body = append([]byte(nil), body...)
+	s.records[key] = rec
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for key, rec := range s.records {
+		if !rec.ExpiresAt.After(now) {
+			delete(s.records, key)
+			n++
+		}
+	}
+	return n, nil
+}
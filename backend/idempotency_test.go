@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStores(t *testing.T) {
+	stores := map[string]IdempotencyStore{
+		"gorm":   NewGormIdempotencyStore(openTestDB(t)),
+		"memory": NewMemoryIdempotencyStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			rec := IdempotencyRecord{Key: "k1", Fingerprint: "f1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+			if _, reserved, err := store.Reserve(ctx, rec); err != nil || !reserved {
+				t.Fatalf("expected first reserve to succeed, got %v, %v", reserved, err)
+			}
+			existing, reserved, err := store.Reserve(ctx, rec)
+			if err != nil || reserved || existing.Fingerprint != "f1" || existing.StatusCode != 0 {
+				t.Fatalf("expected in-flight record, got %+v, %v, %v", existing, reserved, err)
+			}
+
+			if err := store.Complete(ctx, "k1", 201, map[string]string{"Location": "/product/1"}, []byte(`{"ok":true}`)); err != nil {
+				t.Fatalf("complete failed: %v", err)
+			}
+			existing, _, _ = store.Reserve(ctx, rec)
+			if existing.StatusCode != 201 || string(existing.Body) != `{"ok":true}` || existing.Headers["Location"] != "/product/1" {
+				t.Fatalf("expected stored response, got %+v", existing)
+			}
+
+			// released keys can be reserved again
+			if err := store.Release(ctx, "k1"); err != nil {
+				t.Fatalf("release failed: %v", err)
+			}
+			if _, reserved, _ := store.Reserve(ctx, rec); !reserved {
+				t.Fatalf("expected reserve after release to succeed")
+			}
+
+			// expired keys can be reused and are purged
+			later := rec
+			later.CreatedAt = now.Add(2 * time.Hour)
+			later.ExpiresAt = later.CreatedAt.Add(time.Hour)
+			if _, reserved, _ := store.Reserve(ctx, later); !reserved {
+				t.Fatalf("expected expired key to be reusable")
+			}
+			n, err := store.DeleteExpired(ctx, now.Add(4*time.Hour))
+			if err != nil || n != 1 {
+				t.Fatalf("expected 1 expired record deleted, got %d, %v", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Media types accepted by POST /products/import.
+const (
+	mediaTypeCSV    = "text/csv"
+	mediaTypeNDJSON = "application/x-ndjson"
+)
+
+const (
+	// importBatchSize is the number of rows looked up and written together,
+	// and so the most rows held in memory at once.
+	importBatchSize = 500
+	// maxImportErrors bounds the row errors listed in the response; all
+	// invalid rows are still counted as skipped.
+	maxImportErrors = 100
+)
+
+// importRow is one data row of an upload. Err is set when the row can't
+// be read and Fields when it breaks the product rules; in either case
+// Code and Price may be incomplete.
+type importRow struct {
+	Line   int
+	Code   string
+	Price  uint
+	Err    string
+	Fields []FieldError
+}
+
+// importRowError reports an invalid row by its line number in the upload.
+type importRowError struct {
+	Line  int      `json:"line"`
+	Error APIError `json:"error"`
+}
+
+// importSummary is the response of POST /products/import. Skipped counts
+// rows left as they were: unchanged products and invalid rows.
+type importSummary struct {
+	Inserted        int              `json:"inserted"`
+	Updated         int              `json:"updated"`
+	Skipped         int              `json:"skipped"`
+	Errors          []importRowError `json:"errors"`
+	ErrorsTruncated bool             `json:"errors_truncated"`
+	DryRun          bool             `json:"dry_run"`
+}
+
+// importWriteError is a failed write of an import batch, at the row on
+// line Line.
+type importWriteError struct {
+	Line int
+	Err  error
+}
+
+func (e *importWriteError) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+
+func (e *importWriteError) Unwrap() error { return e.Err }
+
+// importReader yields rows until io.EOF. Errors other than io.EOF abort
+// the import; row-level problems are reported through importRow.Err.
+type importReader func() (importRow, error)
+
+// parseImportFields parses the raw code and price of a row and checks
+// them against the product rules.
+func parseImportFields(line int, code, price string) importRow {
+	row := importRow{Line: line, Code: strings.TrimSpace(code)}
+	if price = strings.TrimSpace(price); price != "" {
+		n, err := strconv.ParseUint(price, 10, 0)
+		if err != nil {
+			row.Err = fmt.Sprintf("price %q is not a non-negative integer", price)
+			return row
+		}
+		row.Price = uint(n)
+	}
+	row.Fields = validateProduct(row.Code, row.Price)
+	return row
+}
+
+// csvRows reads a CSV upload whose header row names (at least) the code
+// and price columns, in any order and case. Other columns are ignored.
+func csvRows(r io.Reader) (importReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("CSV header row is missing")
+		}
+		return nil, err
+	}
+	codeCol, priceCol := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "code":
+			codeCol = i
+		case "price":
+			priceCol = i
+		}
+	}
+	if codeCol < 0 || priceCol < 0 {
+		return nil, errors.New("CSV header must include code and price columns")
+	}
+
+	return func() (importRow, error) {
+		record, err := cr.Read()
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				return importRow{Line: perr.StartLine, Err: perr.Err.Error()}, nil
+			}
+			return importRow{}, err
+		}
+		line, _ := cr.FieldPos(0)
+		if codeCol >= len(record) || priceCol >= len(record) {
+			return importRow{Line: line, Err: "row is missing the code or price column"}, nil
+		}
+		return parseImportFields(line, record[codeCol], record[priceCol]), nil
+	}, nil
+}
+
+// ndjsonRows reads one `{"code": ..., "price": ...}` object per line,
+// skipping blank lines.
+func ndjsonRows(r io.Reader) importReader {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+
+	return func() (importRow, error) {
+		for sc.Scan() {
+			line++
+			text := strings.TrimSpace(sc.Text())
+			if text == "" {
+				continue
+			}
+			var obj struct {
+				Code  *string          `json:"code"`
+				Price *json.RawMessage `json:"price"`
+			}
+			if err := json.Unmarshal([]byte(text), &obj); err != nil {
+				return importRow{Line: line, Err: "line is not a JSON object"}, nil
+			}
+			if obj.Code == nil || obj.Price == nil {
+				return importRow{Line: line, Err: "code and price are required"}, nil
+			}
+			return parseImportFields(line, *obj.Code, strings.Trim(string(*obj.Price), `"`)), nil
+		}
+		if err := sc.Err(); err != nil {
+			return importRow{}, err
+		}
+		return importRow{}, io.EOF
+	}
+}
+
+// importBatch applies a batch of valid rows: products are created or have
+// their price updated by code, and rows matching the stored price are
+// skipped. The batch is written in one transaction unless dryRun is set,
+// in which case only the counts are computed. The rows are added to
+// summary only once written, and failures are *importWriteError.
+func importBatch(ctx context.Context, repo ProductRepository, rows []importRow, dryRun bool, summary *importSummary) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	codes := make([]string, 0, len(rows))
+	for _, row := range rows {
+		codes = append(codes, row.Code)
+	}
+	existing, err := repo.GetByCodes(ctx, codes)
+	if err != nil {
+		return &importWriteError{Line: rows[0].Line, Err: err}
+	}
+	prices := make(map[string]uint, len(existing))
+	for _, p := range existing {
+		prices[p.Code] = p.Price
+	}
+
+	var writes []importRow
+	var inserted, updated, skipped int
+	for _, row := range rows {
+		price, ok := prices[row.Code]
+		switch {
+		case ok && price == row.Price:
+			skipped++
+			continue
+		case ok:
+			updated++
+		default:
+			inserted++
+		}
+		prices[row.Code] = row.Price
+		writes = append(writes, row)
+	}
+
+	if !dryRun && len(writes) > 0 {
+		err := repo.Transaction(ctx, func(tx ProductRepository) error {
+			for _, row := range writes {
+				if _, _, err := tx.UpsertByCode(ctx, row.Code, row.Price); err != nil {
+					return &importWriteError{Line: row.Line, Err: err}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	summary.Inserted += inserted
+	summary.Updated += updated
+	summary.Skipped += skipped
+	return nil
+}
+
+// respondImportIncomplete reports an import stopped by err after some
+// batches may have been written: the details carry the line that failed,
+// the first line of the rolled back batch (where a retry can resume) and
+// the summary of the rows written before it.
+func respondImportIncomplete(c *gin.Context, err error, resumeLine int, summary importSummary) {
+	details := internalErrorDetails(c.Request.Context(), fmt.Errorf("%s %s: %w", c.Request.Method, c.Request.URL.Path, err))
+	var werr *importWriteError
+	if errors.As(err, &werr) {
+		details["line"] = werr.Line
+	}
+	details["resume_from_line"] = resumeLine
+	details["summary"] = summary
+	respondErrorCode(c, CodeImportIncomplete, details)
+}
+
+// importProducts serves POST /products/import. The upload is read as a
+// stream and applied importBatchSize rows at a time, so batches before a
+// failing one stay committed; the IMPORT_INCOMPLETE error then says which.
+// With `?dry_run=true` rows are validated and counted without writing.
+func importProducts(c *gin.Context, repo ProductRepository) {
+	var next importReader
+	switch c.ContentType() {
+	case mediaTypeCSV:
+		var err error
+		if next, err = csvRows(c.Request.Body); err != nil {
+			respondErrorCode(c, CodeInvalidRequest, err.Error())
+			return
+		}
+	case mediaTypeNDJSON:
+		next = ndjsonRows(c.Request.Body)
+	default:
+		respondErrorCode(c, CodeUnsupportedMedia, map[string]interface{}{
+			"content_type": c.ContentType(),
+			"supported":    []string{mediaTypeCSV, mediaTypeNDJSON},
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	summary := importSummary{Errors: []importRowError{}, DryRun: c.Query("dry_run") == "true"}
+	batch := make([]importRow, 0, importBatchSize)
+	flush := func() bool {
+		if err := importBatch(ctx, repo, batch, summary.DryRun, &summary); err != nil {
+			respondImportIncomplete(c, err, batch[0].Line, summary)
+			return false
+		}
+		batch = batch[:0]
+		return true
+	}
+
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// earlier batches may be written already; say which
+			respondErrorCode(c, CodeInvalidRequest, map[string]interface{}{"reason": err.Error(), "summary": summary})
+			return
+		}
+		if row.Err != "" || len(row.Fields) > 0 {
+			summary.Skipped++
+			if len(summary.Errors) < maxImportErrors {
+				apiErr := NewAPIError(ctx, CodeInvalidRequest, row.Err)
+				if len(row.Fields) > 0 {
+					apiErr = NewAPIError(ctx, CodeValidationFailed, row.Fields)
+				}
+				summary.Errors = append(summary.Errors, importRowError{Line: row.Line, Error: apiErr})
+			} else {
+				summary.ErrorsTruncated = true
+			}
+			continue
+		}
+		batch = append(batch, row)
+		if len(batch) == importBatchSize && !flush() {
+			return
+		}
+	}
+	if !flush() {
+		return
+	}
+
+	respondSuccess(c, http.StatusOK, summary, nil)
+}
@@ -0,0 +1,12 @@
+// Package locales holds the translated API error messages. Each
+// `<language>.json` file (e.g. `th.json`, `pt-br.json`) maps error codes to
+// messages; codes missing from a catalog fall back to English, which lives
+// in ErrorMessages in errors.go.
+package locales
+
+import "embed"
+
+// FS contains every message catalog in this directory.
+//
+//go:embed *.json
+var FS embed.FS
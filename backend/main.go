@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
@@ -36,8 +45,9 @@ func respondSuccess(c *gin.Context, status int, data interface{}, meta map[strin
 // respondError sends a consistent error JSON envelope.
 func respondError(c *gin.Context, status int, code string, message string, details interface{}) {
 	c.JSON(status, gin.H{
-		"success": false,
-		"status":  status,
+		"success":    false,
+		"status":     status,
+		"request_id": requestIDFrom(c.Request.Context()),
 		"error": gin.H{
 			"code":    code,
 			"message": message,
@@ -47,35 +57,231 @@ func respondError(c *gin.Context, status int, code string, message string, detai
 }
 
 func main() {
+	migrateOnStart := flag.Bool("migrate", os.Getenv("MIGRATE_ON_START") == "true", "apply pending database migrations before serving (or set MIGRATE_ON_START=true)")
+	flag.Parse()
+
 	// Try to load environment variables from the repo-level `.env` (one dir up),
 	// then fall back to a `.env` in the current working dir.
+	var envErr error
 	if err := godotenv.Load("../.env"); err != nil {
 		if err2 := godotenv.Load(); err2 != nil {
-			log.Printf(".env not found (tried ../.env and .env): %v; %v", err, err2)
+			envErr = fmt.Errorf("tried ../.env and .env: %v; %v", err, err2)
 		}
 	}
 
+	// Log JSON to stdout at `LOG_LEVEL` (debug, info, warn or error;
+	// default info). The standard log package is routed through it too.
+	slog.SetDefault(newLogger(os.Stdout, os.Getenv("LOG_LEVEL")))
+	if envErr != nil {
+		slog.Info(".env not found", slog.String("error", envErr.Error()))
+	}
+
 	// Run the frontend generator in non-release (development) mode so
 	// TypeScript types stay in sync during development. In release mode
 	// we skip generation to avoid requiring a Go toolchain at runtime.
 	if gin.Mode() != gin.ReleaseMode {
 		if err := runGenerator(); err != nil {
-			log.Printf("warning: generator failed: %v", err)
+			slog.Warn("generator failed", slog.String("error", err.Error()))
 		}
 	}
 
 	// Initialize DB after loading env.
-	database = db()
+	database := db()
+	if *migrateOnStart {
+		if err := runMigrations(database); err != nil {
+			fatal("migration failed", err)
+		}
+	}
+
+	// SIGINT and SIGTERM start a graceful shutdown; background jobs stop
+	// with it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Expired Idempotency-Key records are purged hourly.
+	idempotencyStore := NewGormIdempotencyStore(database)
+	go purgeIdempotencyKeys(ctx, idempotencyStore, time.Hour)
+
+	// Products stay in the trash for `TRASH_RETENTION` (a duration such as
+	// "720h", the default) before an hourly job removes them for good.
+	repo := NewGormProductRepository(database)
+	trashRetention := 30 * 24 * time.Hour
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			trashRetention = d
+		}
+	}
+	go purgeDeletedProducts(ctx, repo, trashRetention, time.Hour)
+
+	// On shutdown, GET /readyz fails for `SHUTDOWN_DELAY` (default 0)
+	// before the listener closes, then in-flight requests get up to
+	// `SHUTDOWN_TIMEOUT` (default 30s) to finish.
+	shutdownDelay := time.Duration(0)
+	if v := os.Getenv("SHUTDOWN_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			shutdownDelay = d
+		}
+	}
+	shutdownTimeout := 30 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			shutdownTimeout = d
+		}
+	}
+
+	// Time GORM statements and export the pool stats at GET /metrics.
+	metrics := NewMetrics()
+	if err := metrics.InstrumentDB(database); err != nil {
+		fatal("failed to instrument database", err)
+	}
+
+	// Trace requests, DAL calls and SQL, exporting spans to
+	// `OTEL_TRACES_EXPORTER` (otlp, stdout or none, the default).
+	tp, err := newTracerProvider(ctx, os.Getenv("OTEL_TRACES_EXPORTER"))
+	if err != nil {
+		fatal("failed to set up tracing", err)
+	}
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(tracePropagator)
+	if err := InstrumentDBTracing(database, tp); err != nil {
+		fatal("failed to instrument database", err)
+	}
+
+	// Create router and start server on `PORT` (default 8080)
+	state := &shutdownState{}
+	r := newRouter(repo,
+		WithIdempotencyStore(idempotencyStore),
+		WithShutdownState(state),
+		WithMetrics(metrics),
+		WithTracerProvider(tp),
+		WithHealthCheck(databaseCheck(database)),
+		WithHealthCheck(migrationsCheck(database)),
+	)
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("failed to listen on "+addr, err)
+	}
+	slog.Info("listening", slog.String("addr", ln.Addr().String()))
+	srv := &http.Server{Handler: r, ReadHeaderTimeout: 10 * time.Second}
+	if err := serve(ctx, srv, ln, state, shutdownDelay, shutdownTimeout); err != nil {
+		slog.Error("shutdown failed", slog.String("error", err.Error()))
+	}
+
+	// Close the pool once no request can use it anymore.
+	if sqlDB, err := database.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			slog.Error("shutdown: closing database failed", slog.String("error", err.Error()))
+		}
+	}
+	// Flush the spans still buffered for export.
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tp.Shutdown(flushCtx); err != nil {
+		slog.Error("shutdown: flushing traces failed", slog.String("error", err.Error()))
+	}
+	slog.Info("shutdown complete")
+}
+
+// routerOptions holds the optional dependencies of newRouter.
+type routerOptions struct {
+	idempotencyStore IdempotencyStore
+	shutdown         *shutdownState
+	healthChecks     []HealthCheck
+	metrics          *Metrics
+	tracerProvider   trace.TracerProvider
+}
+
+// RouterOption configures newRouter.
+type RouterOption func(*routerOptions)
+
+// WithIdempotencyStore sets the store behind Idempotency-Key support
+// (an in-memory store by default).
+func WithIdempotencyStore(store IdempotencyStore) RouterOption {
+	return func(o *routerOptions) { o.idempotencyStore = store }
+}
+
+// WithHealthCheck adds a check to GET /readyz. Checks are reported in the
+// order they are added.
+func WithHealthCheck(check HealthCheck) RouterOption {
+	return func(o *routerOptions) { o.healthChecks = append(o.healthChecks, check) }
+}
+
+// WithMetrics serves m at GET /metrics (a new registry by default), e.g.
+// one that main has also instrumented the database with. A Metrics can
+// back only one router.
+func WithMetrics(m *Metrics) RouterOption {
+	return func(o *routerOptions) { o.metrics = m }
+}
 
-	// Create router and start server
-	r := newRouter()
-	r.Run()
+// WithTracerProvider traces requests and DAL calls with tp (the global
+// provider, which drops spans unless main has set one, by default).
+func WithTracerProvider(tp trace.TracerProvider) RouterOption {
+	return func(o *routerOptions) { o.tracerProvider = tp }
+}
+
+// WithShutdownState makes GET /readyz fail once state starts draining (a
+// state that never drains by default).
+func WithShutdownState(state *shutdownState) RouterOption {
+	return func(o *routerOptions) { o.shutdown = state }
+}
+
+// corsMiddleware allows any origin, like cors.Default, and lets browsers
+// send and read the headers the API relies on: conditional requests
+// (If-Match, ETag), idempotency keys, request IDs, trace context and the
+// list meta headers of non-JSON responses.
+func corsMiddleware() gin.HandlerFunc {
+	config := cors.DefaultConfig()
+	config.AllowAllOrigins = true
+	config.AllowHeaders = append(config.AllowHeaders,
+		"Accept", "Accept-Language", "Authorization",
+		"If-Match", "If-None-Match", "Idempotency-Key", requestIDHeader,
+		"traceparent", "tracestate", "baggage",
+	)
+	config.ExposeHeaders = []string{
+		"ETag", "Location", "Content-Disposition", "Idempotent-Replayed", requestIDHeader,
+		"Link", "X-Total-Count", "X-Page", "X-Per-Page", "X-Total-Pages",
+		"X-Limit", "X-Next-Cursor", "X-Prev-Cursor",
+	}
+	return cors.New(config)
 }
 
 // newRouter sets up and returns the Gin engine with routes (useful for tests).
-func newRouter() *gin.Engine {
-	r := gin.Default()
-	r.Use(cors.Default())
+// All product data access goes through repo.
+func newRouter(repo ProductRepository, opts ...RouterOption) *gin.Engine {
+	options := routerOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.idempotencyStore == nil {
+		options.idempotencyStore = NewMemoryIdempotencyStore()
+	}
+	if options.shutdown == nil {
+		options.shutdown = &shutdownState{}
+	}
+	if options.metrics == nil {
+		options.metrics = NewMetrics()
+	}
+	options.metrics.registerProductGauges(repo)
+	if options.tracerProvider == nil {
+		options.tracerProvider = otel.GetTracerProvider()
+	}
+	tracer := options.tracerProvider.Tracer(tracerName)
+	repo = newTracedRepository(repo, tracer)
+
+	// Tracing, logging and metrics wrap recoverPanics, so requests whose
+	// handler panicked are still recorded, as 500s.
+	r := gin.New()
+	r.Use(tracingMiddleware(tracer), requestLogger(), options.metrics.middleware(), recoverPanics())
+	r.Use(corsMiddleware())
+
+	// `ERROR_FORMAT=problem` sends errors as RFC 9457 problem+json unless
+	// the client asks for the envelope; by default it's the other way round.
+	r.Use(errorFormat(os.Getenv("ERROR_FORMAT") == "problem"))
+	r.Use(negotiateLanguage())
 
 	// maxPerPage can be configured via env var `MAX_PER_PAGE` (defaults to 100)
 	maxPerPage := 100
@@ -85,11 +291,77 @@ func newRouter() *gin.Engine {
 		}
 	}
 
+	// maxBulkSize bounds POST /products/bulk batches and can be configured
+	// via env var `MAX_BULK_SIZE` (defaults to 1000)
+	maxBulkSize := 1000
+	if v := os.Getenv("MAX_BULK_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxBulkSize = n
+		}
+	}
+
+	// With `REQUIRE_IF_MATCH=true`, PUT/PATCH/DELETE must send If-Match;
+	// otherwise the header is optional but honoured when present.
+	requireIfMatch := os.Getenv("REQUIRE_IF_MATCH") == "true"
+
+	// idempotencyTTL is how long Idempotency-Key responses are kept, set via
+	// `IDEMPOTENCY_TTL` as a duration such as "24h" (the default).
+	idempotencyTTL := 24 * time.Hour
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			idempotencyTTL = d
+		}
+	}
+
+	// `HEALTH_CHECK_TIMEOUT` (a duration, default 2s) bounds each readiness
+	// check.
+	healthCheckTimeout := 2 * time.Second
+	if v := os.Getenv("HEALTH_CHECK_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			healthCheckTimeout = d
+		}
+	}
+
+	// `ADMIN_TOKEN` enables admin-only operations for requests sending it
+	// as a bearer token; when unset they are refused.
+	adminToken := os.Getenv("ADMIN_TOKEN")
+
 	r.GET("/ping", func(c *gin.Context) {
 		respondSuccess(c, http.StatusOK, gin.H{"message": "pong"}, nil)
 	})
 
-	r.GET("/products", func(c *gin.Context) {
+	r.GET("/errors", listErrorCodes)
+
+	// Prometheus metrics: HTTP, database and product gauges.
+	r.GET("/metrics", options.metrics.handler())
+
+	// Liveness: the process is up and serving requests.
+	r.GET("/healthz", func(c *gin.Context) {
+		respondSuccess(c, http.StatusOK, gin.H{"status": "alive"}, nil)
+	})
+
+	// Readiness for load balancers: runs the registered checks and fails
+	// as soon as shutdown begins.
+	r.GET("/readyz", func(c *gin.Context) {
+		readyz(c, options.healthChecks, healthCheckTimeout, options.shutdown)
+	})
+
+	r.GET("/products", negotiateProducts(), func(c *gin.Context) {
+		query, qerr := parseProductQuery(c.Request.URL.Query())
+		if qerr != nil {
+			respondErrorCode(c, CodeInvalidFilter, qerr.Details())
+			return
+		}
+
+		// `cursor` or `limit` selects keyset pagination; otherwise pages
+		// are addressed by offset as before.
+		_, hasCursor := c.GetQuery("cursor")
+		_, hasLimit := c.GetQuery("limit")
+		if hasCursor || hasLimit {
+			listProductsByCursor(c, repo, query, maxPerPage)
+			return
+		}
+
 		// Pagination parameters
 		pageStr := c.DefaultQuery("page", "1")
 		perPageStr := c.DefaultQuery("per_page", "20")
@@ -103,13 +375,13 @@ func newRouter() *gin.Engine {
 		}
 
 		if perPage > maxPerPage {
-			RespondBadRequest(c, CodePerPageTooLarge, map[string]interface{}{"requested": perPage, "max_per_page": maxPerPage})
+			respondErrorCode(c, CodePerPageTooLarge, map[string]interface{}{"requested": perPage, "max_per_page": maxPerPage})
 			return
 		}
 
-		products, total, err := getAllProducts(page, perPage)
+		products, total, err := repo.List(c.Request.Context(), query, page, perPage)
 		if err != nil {
-			RespondInternal(c, CodeInternalError, err.Error())
+			respondInternalError(c, err)
 			return
 		}
 
@@ -125,88 +397,278 @@ func newRouter() *gin.Engine {
 			"total_pages": totalPages,
 		}
 
-		respondSuccess(c, http.StatusOK, products, meta)
+		respondProducts(c, http.StatusOK, products, meta)
+	})
+
+	r.POST("/products/bulk", idempotent(options.idempotencyStore, idempotencyTTL), func(c *gin.Context) {
+		bulkProducts(c, repo, maxBulkSize, requireIfMatch)
+	})
+
+	r.GET("/products/export", func(c *gin.Context) {
+		exportProducts(c, repo)
+	})
+
+	r.POST("/products/import", func(c *gin.Context) {
+		importProducts(c, repo)
 	})
 
-	r.GET("/product/latest", func(c *gin.Context) {
-		var product, err = getLatestProduct()
+	r.GET("/products/trash", negotiateProducts(), func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		perPage, err := strconv.Atoi(c.DefaultQuery("per_page", "20"))
+		if err != nil || perPage < 1 {
+			perPage = 20
+		}
+		if perPage > maxPerPage {
+			respondErrorCode(c, CodePerPageTooLarge, map[string]interface{}{"requested": perPage, "max_per_page": maxPerPage})
+			return
+		}
+
+		products, total, err := repo.ListDeleted(c.Request.Context(), page, perPage)
 		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				RespondNotFound(c, CodeProductNotFound, nil)
-				return
-			}
-			RespondInternal(c, CodeInternalError, err.Error())
+			respondInternalError(c, err)
 			return
 		}
-		respondSuccess(c, http.StatusOK, product, nil)
+
+		totalPages := 0
+		if total > 0 {
+			totalPages = int((total + int64(perPage) - 1) / int64(perPage))
+		}
+		respondProducts(c, http.StatusOK, products, map[string]interface{}{
+			"page":        page,
+			"per_page":    perPage,
+			"total":       total,
+			"total_pages": totalPages,
+		})
 	})
 
-	r.GET("/product/:id", func(c *gin.Context) {
+	r.GET("/product/latest", negotiateProducts(), func(c *gin.Context) {
+		by, ok := parseLatestBy(c)
+		if !ok {
+			return
+		}
+
+		products, err := repo.Latest(c.Request.Context(), by, 1)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		if len(products) == 0 {
+			respondErrorCode(c, CodeProductNotFound, nil)
+			return
+		}
+		respondProduct(c, http.StatusOK, products[0])
+	})
+
+	r.GET("/products/latest", negotiateProducts(), func(c *gin.Context) {
+		by, ok := parseLatestBy(c)
+		if !ok {
+			return
+		}
+
+		nStr := c.DefaultQuery("n", "10")
+		n, err := strconv.Atoi(nStr)
+		if err != nil || n < 1 {
+			respondErrorCode(c, CodeInvalidRequest, map[string]interface{}{"n": nStr})
+			return
+		}
+		if n > maxPerPage {
+			respondErrorCode(c, CodePerPageTooLarge, map[string]interface{}{"requested": n, "max_per_page": maxPerPage})
+			return
+		}
+
+		products, err := repo.Latest(c.Request.Context(), by, n)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+
+		respondProducts(c, http.StatusOK, products, map[string]interface{}{"by": by, "n": n})
+	})
+
+	r.GET("/product/:id", negotiateProducts(), func(c *gin.Context) {
 		idParam := c.Param("id")
-		product, err := getProductByID(idParam)
+
+		var id uint
+		_, err := fmt.Sscanf(idParam, "%d", &id)
+		if err != nil {
+			respondErrorCode(c, CodeInvalidID, nil)
+			return
+		}
+
+		product, err := repo.GetByID(c.Request.Context(), id)
 		if err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				RespondNotFound(c, CodeProductNotFound, nil)
+				respondErrorCode(c, CodeProductNotFound, nil)
 				return
 			}
-			RespondInternal(c, CodeInternalError, err.Error())
+			respondInternalError(c, err)
 			return
 		}
-		respondSuccess(c, http.StatusOK, product, nil)
+		respondProduct(c, http.StatusOK, product)
 	})
 
-	r.POST("/product", func(c *gin.Context) {
-		var json struct {
-			Code  string `json:"code" binding:"required"`
-			Price uint   `json:"price" binding:"required"`
-		}
-
+	r.POST("/product", idempotent(options.idempotencyStore, idempotencyTTL), func(c *gin.Context) {
+		var json productPayload
 		if err := c.ShouldBindJSON(&json); err != nil {
-			RespondBadRequest(c, CodeInvalidRequest, err.Error())
+			respondBindError(c, err)
 			return
 		}
 
-		created, err := addProduct(json.Code, json.Price)
+		created, err := repo.Create(c.Request.Context(), json.Code, json.Price)
 		if err != nil {
-			RespondInternal(c, CodeInternalError, err.Error())
+			if errors.Is(err, ErrCodeConflict) {
+				respondCodeConflict(c, json.Code)
+				return
+			}
+			respondInternalError(c, err)
 			return
 		}
 
 		// Set Location header for the created resource
 		c.Header("Location", fmt.Sprintf("/product/%d", created.ID))
-		respondSuccess(c, http.StatusCreated, created, nil)
+		respondProduct(c, http.StatusCreated, created)
 	})
 
 	r.PUT("/product/:id", func(c *gin.Context) {
 		idParam := c.Param("id")
+		var json productPayload
+		if err := c.ShouldBindJSON(&json); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		var id uint
+		_, err := fmt.Sscanf(idParam, "%d", &id)
+		if err != nil {
+			respondErrorCode(c, CodeInvalidID, nil)
+			return
+		}
+
+		current, ok := loadForWrite(c, repo, id, requireIfMatch)
+		if !ok {
+			return
+		}
+
+		updated, err := repo.Update(c.Request.Context(), id, ProductChanges{Code: &json.Code, Price: &json.Price}, current.Version)
+		if err != nil {
+			if errors.Is(err, ErrVersionConflict) {
+				respondVersionConflict(c, repo, id)
+				return
+			}
+			if errors.Is(err, ErrCodeConflict) {
+				respondCodeConflict(c, json.Code)
+				return
+			}
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				respondErrorCode(c, CodeProductNotFound, nil)
+				return
+			}
+			respondInternalError(c, err)
+			return
+		}
+
+		respondProduct(c, http.StatusOK, updated)
+	})
+
+	// PUT /product/by-code/:code creates the product with that code or
+	// updates the price of the existing one in a single atomic statement.
+	r.PUT("/product/by-code/:code", func(c *gin.Context) {
+		code := c.Param("code")
 		var json struct {
-			Code  string `json:"code" binding:"required"`
-			Price uint   `json:"price" binding:"required"`
+			Price uint `json:"price"`
 		}
 
 		if err := c.ShouldBindJSON(&json); err != nil {
-			RespondBadRequest(c, CodeInvalidRequest, err.Error())
+			respondBindError(c, err)
+			return
+		}
+		// the code comes from the path, so validate both here
+		if fields := validateProduct(code, json.Price); len(fields) > 0 {
+			respondErrorCode(c, CodeValidationFailed, fields)
+			return
+		}
+
+		product, created, err := repo.UpsertByCode(c.Request.Context(), code, json.Price)
+		if err != nil {
+			if errors.Is(err, ErrCodeConflict) {
+				respondCodeConflict(c, code)
+				return
+			}
+			respondInternalError(c, err)
 			return
 		}
 
+		if created {
+			c.Header("Location", fmt.Sprintf("/product/%d", product.ID))
+			respondProduct(c, http.StatusCreated, product)
+			return
+		}
+		respondProduct(c, http.StatusOK, product)
+	})
+
+	r.PATCH("/product/:id", func(c *gin.Context) {
+		idParam := c.Param("id")
+
 		var id uint
 		_, err := fmt.Sscanf(idParam, "%d", &id)
 		if err != nil {
-			RespondBadRequest(c, CodeInvalidID, nil)
+			respondErrorCode(c, CodeInvalidID, nil)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondErrorCode(c, CodeInvalidRequest, err.Error())
+			return
+		}
+
+		product, ok := loadForWrite(c, repo, id, requireIfMatch)
+		if !ok {
+			return
+		}
+
+		changes, perr := applyProductPatch(product, c.ContentType(), body)
+		if perr != nil {
+			if perr.cause != nil {
+				respondInternalError(c, perr.cause)
+				return
+			}
+			respondErrorCode(c, perr.code, perr.details)
+			return
+		}
+		if fields := validateProductChanges(changes); len(fields) > 0 {
+			respondErrorCode(c, CodeValidationFailed, fields)
+			return
+		}
+		// a patch that changes nothing keeps the version, and so the ETag
+		if changes.Code == nil && changes.Price == nil {
+			respondProduct(c, http.StatusOK, product)
 			return
 		}
 
-		updated, err := updateProduct(id, json.Code, json.Price)
+		// the patch was computed from this version; don't apply it to another
+		updated, err := repo.Update(c.Request.Context(), id, changes, product.Version)
 		if err != nil {
+			if errors.Is(err, ErrVersionConflict) {
+				respondVersionConflict(c, repo, id)
+				return
+			}
+			if errors.Is(err, ErrCodeConflict) {
+				respondCodeConflict(c, *changes.Code)
+				return
+			}
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				RespondNotFound(c, CodeProductNotFound, nil)
+				respondErrorCode(c, CodeProductNotFound, nil)
 				return
 			}
-			RespondInternal(c, CodeInternalError, err.Error())
+			respondInternalError(c, err)
 			return
 		}
 
-		respondSuccess(c, http.StatusOK, updated, nil)
+		respondProduct(c, http.StatusOK, updated)
 	})
 
 	r.DELETE("/product/:id", func(c *gin.Context) {
@@ -215,36 +677,117 @@ func newRouter() *gin.Engine {
 		var id uint
 		_, err := fmt.Sscanf(idParam, "%d", &id)
 		if err != nil {
-			RespondBadRequest(c, CodeInvalidID, nil)
+			respondErrorCode(c, CodeInvalidID, nil)
 			return
 		}
 
-		if err := deleteProduct(id); err != nil {
+		// `?hard=true` bypasses the trash and removes the row for good,
+		// whether or not it was soft-deleted already.
+		if c.Query("hard") == "true" {
+			if !isAdmin(c, adminToken) {
+				respondErrorCode(c, CodeAdminRequired, nil)
+				return
+			}
+			if err := repo.Purge(c.Request.Context(), id); err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					respondErrorCode(c, CodeProductNotFound, nil)
+					return
+				}
+				respondInternalError(c, err)
+				return
+			}
+			respondSuccess(c, http.StatusOK, gin.H{"message": "product permanently deleted"}, nil)
+			return
+		}
+
+		// Only an explicit If-Match makes the delete conditional.
+		var ifVersion uint
+		if c.GetHeader("If-Match") != "" || requireIfMatch {
+			current, ok := loadForWrite(c, repo, id, requireIfMatch)
+			if !ok {
+				return
+			}
+			ifVersion = current.Version
+		}
+
+		if err := repo.Delete(c.Request.Context(), id, ifVersion); err != nil {
+			if errors.Is(err, ErrVersionConflict) {
+				respondVersionConflict(c, repo, id)
+				return
+			}
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				RespondNotFound(c, CodeProductNotFound, nil)
+				respondErrorCode(c, CodeProductNotFound, nil)
 				return
 			}
-			RespondInternal(c, CodeInternalError, err.Error())
+			respondInternalError(c, err)
 			return
 		}
 
 		respondSuccess(c, http.StatusOK, gin.H{"message": "product deleted"}, nil)
 	})
 
+	r.POST("/product/:id/restore", func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		var id uint
+		_, err := fmt.Sscanf(idParam, "%d", &id)
+		if err != nil {
+			respondErrorCode(c, CodeInvalidID, nil)
+			return
+		}
+
+		restored, err := repo.Restore(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				respondErrorCode(c, CodeProductNotFound, map[string]interface{}{"id": id, "reason": "no deleted product with this id"})
+				return
+			}
+			if errors.Is(err, ErrCodeConflict) {
+				respondErrorCode(c, CodeProductCodeConflict, map[string]interface{}{"id": id})
+				return
+			}
+			respondInternalError(c, err)
+			return
+		}
+
+		respondProduct(c, http.StatusOK, restored)
+	})
+
 	return r
 }
 
+// parseLatestBy reads the `by` query parameter used by the "latest"
+// endpoints (created_at by default). On an invalid value it responds with
+// a bad request and returns false.
+func parseLatestBy(c *gin.Context) (LatestBy, bool) {
+	by := LatestBy(c.DefaultQuery("by", string(LatestByCreatedAt)))
+	if !by.Valid() {
+		respondErrorCode(c, CodeInvalidRequest, map[string]interface{}{
+			"by":      string(by),
+			"allowed": []LatestBy{LatestByCreatedAt, LatestByUpdatedAt},
+		})
+		return "", false
+	}
+	return by, true
+}
+
+// respondCodeConflict responds 409 for a write that would reuse the code
+// of another live product.
+func respondCodeConflict(c *gin.Context, code string) {
+	respondErrorCode(c, CodeProductCodeConflict, map[string]interface{}{"code": code})
+}
+
 // runGenerator runs the small Go CLI that emits TypeScript types into the
 // frontend source tree. It intentionally logs output and returns an error
 // if the generator fails; callers can decide how to handle the error.
 func runGenerator() error {
-	// Run `go run ./cmd/genfrontend -out ../frontend/src` from backend dir
-	cmd := exec.Command("go", "run", "./cmd/genfrontend", "-out", "../frontend/src")
+	// Run `go run ./cmd/genfrontend -src . -out ../frontend/src` from backend dir
+	cmd := exec.Command("go", "run", "./cmd/genfrontend", "-src", ".", "-out", "../frontend/src")
 	cmd.Env = os.Environ()
 	// keep working dir as backend (where main.go lives)
 	out, err := cmd.CombinedOutput()
 	if len(out) > 0 {
-		log.Printf("generator output: %s", string(out))
+		slog.Info("generator output", slog.String("output", string(out)))
 	}
 	if err != nil {
 		return fmt.Errorf("generator failed: %w", err)
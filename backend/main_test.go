@@ -1,40 +1,59 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"os"
 
 	"github.com/gin-gonic/gin"
-	"github.com/glebarez/sqlite"
+	"github.com/ugorji/go/codec"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
+
+	"may/dbconn"
 )
 
-// helper to setup in-memory DB and router
-func setupTestRouter(t *testing.T) *gin.Engine {
+// helper to open an in-memory sqlite DB with the schema migrations applied
+func openTestDB(t *testing.T) *gorm.DB {
 	// initialize in-memory sqlite
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db, err := dbconn.Open("sqlite::memory:", &gorm.Config{})
 	if err != nil {
 		t.Fatalf("failed to open in-memory db: %v", err)
 	}
 
 	// run migrations
-	if err := db.AutoMigrate(&Product{}); err != nil {
-		t.Fatalf("auto migrate failed: %v", err)
+	if err := runMigrations(db); err != nil {
+		t.Fatalf("migrations failed: %v", err)
 	}
+	return db
+}
 
-	// assign to package-level database var used by DAL
-	database = db
+// helper to setup in-memory DB and router; the DB is returned for seeding
+func setupTestRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	db := openTestDB(t)
 
 	// reduce test noise
 	gin.SetMode(gin.TestMode)
 
-	return newRouter()
+	return newRouter(NewGormProductRepository(db)), db
 }
 
 // decode envelope into map for assertions
@@ -47,12 +66,12 @@ func decodeEnvelope(t *testing.T, resp *httptest.ResponseRecorder) map[string]in
 }
 
 func TestGETProductsPagination(t *testing.T) {
-	r := setupTestRouter(t)
+	r, db := setupTestRouter(t)
 
 	// seed 25 products
 	for i := 1; i <= 25; i++ {
 		p := Product{Code: "code" + strconv.Itoa(i), Price: uint(i)}
-		_ = database.Create(&p)
+		_ = db.Create(&p)
 	}
 
 	req := httptest.NewRequest(http.MethodGet, "/products?page=2&per_page=10", nil)
@@ -106,7 +125,7 @@ func TestGETProductsPagination(t *testing.T) {
 }
 
 func TestPOSTCreatesProduct(t *testing.T) {
-	r := setupTestRouter(t)
+	r, _ := setupTestRouter(t)
 
 	payload := map[string]interface{}{"code": "new-code", "price": 42}
 	b, _ := json.Marshal(payload)
@@ -137,10 +156,10 @@ func TestPOSTCreatesProduct(t *testing.T) {
 }
 
 func TestPUTUpdatesProduct(t *testing.T) {
-	r := setupTestRouter(t)
+	r, db := setupTestRouter(t)
 
 	p := Product{Code: "orig", Price: 5}
-	_ = database.Create(&p)
+	_ = db.Create(&p)
 
 	payload := map[string]interface{}{"code": "updated", "price": 99}
 	b, _ := json.Marshal(payload)
@@ -165,10 +184,10 @@ func TestPUTUpdatesProduct(t *testing.T) {
 }
 
 func TestGETProductByID(t *testing.T) {
-	r := setupTestRouter(t)
+	r, db := setupTestRouter(t)
 
 	p := Product{Code: "byid", Price: 7}
-	_ = database.Create(&p)
+	_ = db.Create(&p)
 
 	req := httptest.NewRequest(http.MethodGet, "/product/"+strconv.FormatUint(uint64(p.ID), 10), nil)
 	w := httptest.NewRecorder()
@@ -190,13 +209,15 @@ func TestGETProductByID(t *testing.T) {
 }
 
 func TestGETLatestProduct(t *testing.T) {
-	r := setupTestRouter(t)
+	r, db := setupTestRouter(t)
 
-	// create two products; getLatestProduct currently returns first by primary key
+	// create two products; the most recently created one is the latest
+	older := time.Now().Add(-time.Hour)
 	p1 := Product{Code: "first", Price: 1}
+	p1.CreatedAt = older
 	p2 := Product{Code: "second", Price: 2}
-	_ = database.Create(&p1)
-	_ = database.Create(&p2)
+	_ = db.Create(&p1)
+	_ = db.Create(&p2)
 
 	req := httptest.NewRequest(http.MethodGet, "/product/latest", nil)
 	w := httptest.NewRecorder()
@@ -212,13 +233,117 @@ func TestGETLatestProduct(t *testing.T) {
 	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
 		t.Fatalf("failed to decode latest product: %v", err)
 	}
-	if raw.Data.ID != p1.ID {
-		t.Fatalf("expected latest product id %d, got %d", p1.ID, raw.Data.ID)
+	if raw.Data.ID != p2.ID {
+		t.Fatalf("expected latest product id %d, got %d", p2.ID, raw.Data.ID)
+	}
+}
+
+func TestGETLatestProductTiesAndUpdatedAt(t *testing.T) {
+	r, db := setupTestRouter(t)
+
+	// three products sharing one created_at; ties are broken by highest id
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var ids []uint
+	for i := 0; i < 3; i++ {
+		p := Product{Code: "tie" + strconv.Itoa(i), Price: uint(i)}
+		p.CreatedAt = stamp
+		p.UpdatedAt = stamp
+		_ = db.Create(&p)
+		ids = append(ids, p.ID)
+	}
+	// a deleted product must never be returned, even if it is newest
+	gone := Product{Code: "gone", Price: 1}
+	_ = db.Create(&gone)
+	_ = db.Delete(&gone)
+	// touching the first product makes it the most recently updated
+	_ = db.Model(&Product{}).Where("id = ?", ids[0]).Update("price", 100)
+
+	latest := func(query string) Product {
+		req := httptest.NewRequest(http.MethodGet, "/product/latest"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %q, got %d", query, w.Code)
+		}
+		var raw struct {
+			Data Product `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+			t.Fatalf("failed to decode latest product: %v", err)
+		}
+		return raw.Data
+	}
+
+	if got := latest(""); got.ID != ids[2] {
+		t.Fatalf("expected tie broken by highest id %d, got %d", ids[2], got.ID)
+	}
+	if got := latest("?by=updated_at"); got.ID != ids[0] {
+		t.Fatalf("expected most recently updated id %d, got %d", ids[0], got.ID)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/product/latest?by=price", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unsupported by column, got %d", w.Code)
+	}
+}
+
+func TestGETLatestProductEmpty(t *testing.T) {
+	r, _ := setupTestRouter(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/product/latest", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with no products, got %d", w.Code)
+	}
+}
+
+func TestGETProductsLatest(t *testing.T) {
+	r, db := setupTestRouter(t)
+
+	base := time.Now().Add(-time.Hour)
+	for i := 1; i <= 5; i++ {
+		p := Product{Code: "code" + strconv.Itoa(i), Price: uint(i)}
+		// products 4 and 5 share a timestamp
+		p.CreatedAt = base.Add(time.Duration(min(i, 4)) * time.Minute)
+		_ = db.Create(&p)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/products/latest?n=3", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var raw struct {
+		Data []Product `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("failed to decode latest products: %v", err)
+	}
+	var codes []string
+	for _, p := range raw.Data {
+		codes = append(codes, p.Code)
+	}
+	if strings.Join(codes, ",") != "code5,code4,code3" {
+		t.Fatalf("unexpected latest order: %v", codes)
+	}
+
+	for _, query := range []string{"?n=0", "?n=abc", "?n=1000"} {
+		req := httptest.NewRequest(http.MethodGet, "/products/latest"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %q, got %d", query, w.Code)
+		}
 	}
 }
 
 func TestPOSTLocationHeaderAndBadRequest(t *testing.T) {
-	r := setupTestRouter(t)
+	r, _ := setupTestRouter(t)
 
 	// Bad request: missing price
 	payload := map[string]interface{}{"code": "x"}
@@ -248,7 +373,7 @@ func TestPOSTLocationHeaderAndBadRequest(t *testing.T) {
 }
 
 func TestPerPageLimit(t *testing.T) {
-	r := setupTestRouter(t)
+	r, _ := setupTestRouter(t)
 
 	// Request with excessive per_page
 	req := httptest.NewRequest(http.MethodGet, "/products?per_page=1000", nil)
@@ -264,7 +389,7 @@ func TestPerPageEnvOverride(t *testing.T) {
 	_ = os.Setenv("MAX_PER_PAGE", "5")
 	defer os.Unsetenv("MAX_PER_PAGE")
 
-	r := setupTestRouter(t)
+	r, _ := setupTestRouter(t)
 
 	// Request with per_page greater than env max
 	req := httptest.NewRequest(http.MethodGet, "/products?per_page=10", nil)
@@ -289,7 +414,7 @@ func TestPerPageEnvOverride(t *testing.T) {
 }
 
 func TestPUTNotFoundAndDELETENotFound(t *testing.T) {
-	r := setupTestRouter(t)
+	r, _ := setupTestRouter(t)
 
 	// Attempt to update non-existent product id 999
 	payload := map[string]interface{}{"code": "x", "price": 1}
@@ -320,3 +445,1543 @@ func TestPUTNotFoundAndDELETENotFound(t *testing.T) {
 		t.Fatalf("expected %s error code for delete, got %v", CodeProductNotFound, env2["error"])
 	}
 }
+
+func TestGETProductsFilterAndSort(t *testing.T) {
+	r, db := setupTestRouter(t)
+
+	for i := 1; i <= 10; i++ {
+		p := Product{Code: "code" + strconv.Itoa(i), Price: uint(i * 100)}
+		_ = db.Create(&p)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/products?sort=-price&price[gte]=300&price[lt]=700&per_page=2", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var raw struct {
+		Data []Product              `json:"data"`
+		Meta map[string]interface{} `json:"meta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("failed to decode products: %v", err)
+	}
+	if len(raw.Data) != 2 || raw.Data[0].Price != 600 || raw.Data[1].Price != 500 {
+		t.Fatalf("unexpected filtered page: %+v", raw.Data)
+	}
+	if int(raw.Meta["total"].(float64)) != 4 {
+		t.Fatalf("expected total=4 matching products, got %v", raw.Meta["total"])
+	}
+}
+
+func TestGETProductsInvalidFilter(t *testing.T) {
+	r, _ := setupTestRouter(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/products?password[eq]=x", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown filter field, got %d", w.Code)
+	}
+
+	env := decodeEnvelope(t, w)
+	errObj := env["error"].(map[string]interface{})
+	if errObj["code"] != CodeInvalidFilter {
+		t.Fatalf("expected %s, got %v", CodeInvalidFilter, errObj["code"])
+	}
+	details := errObj["details"].(map[string]interface{})
+	if details["parameter"] != "password[eq]" {
+		t.Fatalf("expected details to name the parameter, got %v", details)
+	}
+}
+
+func TestGETProductsCursorPagination(t *testing.T) {
+	r, db := setupTestRouter(t)
+
+	for i := 1; i <= 7; i++ {
+		p := Product{Code: "code" + strconv.Itoa(i), Price: uint(i % 3)}
+		_ = db.Create(&p)
+	}
+
+	type page struct {
+		Data []Product `json:"data"`
+		Meta struct {
+			Limit      int     `json:"limit"`
+			NextCursor *string `json:"next_cursor"`
+			PrevCursor *string `json:"prev_cursor"`
+			Total      *int    `json:"total"`
+		} `json:"meta"`
+	}
+	get := func(query string) page {
+		req := httptest.NewRequest(http.MethodGet, "/products?"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %q, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var p page
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("failed to decode page: %v", err)
+		}
+		return p
+	}
+
+	first := get("sort=-price&limit=3")
+	if codes(first.Data) != "code2,code5,code1" || first.Meta.PrevCursor != nil || first.Meta.NextCursor == nil {
+		t.Fatalf("unexpected first page: %s, meta %+v", codes(first.Data), first.Meta)
+	}
+	if first.Meta.Total == nil || *first.Meta.Total != 7 {
+		t.Fatalf("expected total=7 by default, got %v", first.Meta.Total)
+	}
+
+	// a row inserted ahead of the cursor doesn't shift the next page
+	_ = db.Create(&Product{Code: "late", Price: 2})
+
+	second := get("sort=-price&limit=3&include_total=false&cursor=" + *first.Meta.NextCursor)
+	if codes(second.Data) != "code4,code7,code3" || second.Meta.Total != nil {
+		t.Fatalf("unexpected second page: %s, meta %+v", codes(second.Data), second.Meta)
+	}
+
+	third := get("sort=-price&limit=3&cursor=" + *second.Meta.NextCursor)
+	if codes(third.Data) != "code6" || third.Meta.NextCursor != nil || third.Meta.PrevCursor == nil {
+		t.Fatalf("unexpected last page: %s, meta %+v", codes(third.Data), third.Meta)
+	}
+
+	back := get("sort=-price&limit=3&cursor=" + *third.Meta.PrevCursor)
+	if codes(back.Data) != "code4,code7,code3" || back.Meta.PrevCursor == nil || back.Meta.NextCursor == nil {
+		t.Fatalf("unexpected previous page: %s, meta %+v", codes(back.Data), back.Meta)
+	}
+
+	// cursors are bound to the sort they were issued for
+	for _, query := range []string{"sort=price&limit=3&cursor=" + *first.Meta.NextCursor, "limit=3&cursor=not-a-cursor"} {
+		req := httptest.NewRequest(http.MethodGet, "/products?"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %q, got %d", query, w.Code)
+		}
+		env := decodeEnvelope(t, w)
+		if code := env["error"].(map[string]interface{})["code"]; code != CodeInvalidCursor {
+			t.Fatalf("expected %s, got %v", CodeInvalidCursor, code)
+		}
+	}
+}
+
+func TestPATCHProduct(t *testing.T) {
+	r, db := setupTestRouter(t)
+
+	p := Product{Code: "orig", Price: 5}
+	_ = db.Create(&p)
+	path := "/product/" + strconv.FormatUint(uint64(p.ID), 10)
+
+	patch := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	current := func() Product {
+		var got Product
+		_ = db.First(&got, p.ID)
+		return got
+	}
+
+	// merge patch touches only the provided field
+	w := patch(mediaTypeMergePatch, `{"price": 7}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for merge patch, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := current(); got.Code != "orig" || got.Price != 7 {
+		t.Fatalf("unexpected product after merge patch: %+v", got)
+	}
+
+	// JSON patch with a passing test op; Go-style field names are accepted
+	w = patch(mediaTypeJSONPatch, `[{"op":"test","path":"/ID","value":`+strconv.FormatUint(uint64(p.ID), 10)+`},{"op":"replace","path":"/Code","value":"patched"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for JSON patch, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := current(); got.Code != "patched" || got.Price != 7 {
+		t.Fatalf("unexpected product after JSON patch: %+v", got)
+	}
+
+	cases := []struct {
+		name, contentType, body string
+		status                  int
+		code                    string
+	}{
+		{"merge read-only", mediaTypeMergePatch, `{"ID": 7}`, http.StatusBadRequest, CodeReadOnlyField},
+		{"merge remove", mediaTypeMergePatch, `{"code": null}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"merge unknown", mediaTypeMergePatch, `{"sku": "x"}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"merge wrong type", mediaTypeMergePatch, `{"price": "free"}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"json read-only", mediaTypeJSONPatch, `[{"op":"replace","path":"/created_at","value":"2020-01-01T00:00:00Z"}]`, http.StatusBadRequest, CodeReadOnlyField},
+		{"json move read-only", mediaTypeJSONPatch, `[{"op":"move","from":"/UpdatedAt","path":"/code"}]`, http.StatusBadRequest, CodeReadOnlyField},
+		{"json version", mediaTypeJSONPatch, `[{"op":"replace","path":"/version","value":9}]`, http.StatusBadRequest, CodeReadOnlyField},
+		{"json failed test", mediaTypeJSONPatch, `[{"op":"test","path":"/code","value":"other"},{"op":"replace","path":"/price","value":1}]`, http.StatusUnprocessableEntity, CodePatchFailed},
+		{"merge invalid price", mediaTypeMergePatch, `{"price": 0}`, http.StatusBadRequest, CodeValidationFailed},
+		{"json invalid code", mediaTypeJSONPatch, `[{"op":"replace","path":"/code","value":"has space"}]`, http.StatusBadRequest, CodeValidationFailed},
+		{"plain json", "application/json", `{"price": 1}`, http.StatusUnsupportedMediaType, CodeUnsupportedMedia},
+	}
+	for _, tc := range cases {
+		w := patch(tc.contentType, tc.body)
+		if w.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.status, w.Code, w.Body.String())
+		}
+		env := decodeEnvelope(t, w)
+		if code := env["error"].(map[string]interface{})["code"]; code != tc.code {
+			t.Fatalf("%s: expected %s, got %v", tc.name, tc.code, code)
+		}
+	}
+	if got := current(); got.Code != "patched" || got.Price != 7 {
+		t.Fatalf("rejected patches must not change the product: %+v", got)
+	}
+
+	// patches that change nothing leave the version, and the ETag, alone
+	before := current().Version
+	for _, body := range []string{`{}`, `{"price": 7}`} {
+		if w := patch(mediaTypeMergePatch, body); w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for no-op patch %s, got %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+	w = patch(mediaTypeJSONPatch, `[{"op":"test","path":"/version","value":`+strconv.FormatUint(uint64(before), 10)+`}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a test of the version, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := current().Version; got != before {
+		t.Fatalf("expected no-op patches to keep version %d, got %d", before, got)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/product/999", strings.NewReader(`{"price": 1}`))
+	req.Header.Set("Content-Type", mediaTypeMergePatch)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 patching missing product, got %d", w.Code)
+	}
+}
+
+func TestETagConditionalRequests(t *testing.T) {
+	r, db := setupTestRouter(t)
+
+	p := Product{Code: "etag", Price: 1}
+	_ = db.Create(&p)
+	path := "/product/" + strconv.FormatUint(uint64(p.ID), 10)
+
+	do := func(method, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodGet, "", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != `"1-1"` {
+		t.Fatalf("expected 200 with ETag \"1-1\", got %d %q", w.Code, etag)
+	}
+
+	w = do(http.MethodGet, "", map[string]string{"If-None-Match": `W/"1-1"`})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected empty 304 for matching If-None-Match, got %d", w.Code)
+	}
+
+	w = do(http.MethodPut, `{"code":"a","price":2}`, map[string]string{"Content-Type": "application/json", "If-Match": etag})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1-2"` {
+		t.Fatalf("expected 200 with ETag \"1-2\" for matching If-Match, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	// the first editor's ETag is now stale
+	w = do(http.MethodPatch, `{"price":3}`, map[string]string{"Content-Type": mediaTypeMergePatch, "If-Match": etag})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale If-Match, got %d", w.Code)
+	}
+	env := decodeEnvelope(t, w)
+	errObj := env["error"].(map[string]interface{})
+	if errObj["code"] != CodePreconditionFailed || errObj["details"].(map[string]interface{})["current_etag"] != `"1-2"` {
+		t.Fatalf("unexpected 412 body: %v", errObj)
+	}
+
+	w = do(http.MethodDelete, "", map[string]string{"If-Match": etag})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 deleting with stale If-Match, got %d", w.Code)
+	}
+
+	w = do(http.MethodGet, "", map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for stale If-None-Match, got %d", w.Code)
+	}
+
+	// the tag of any representation of the current version will do
+	w = do(http.MethodDelete, "", map[string]string{"If-Match": `"1-2-csv"`})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 deleting with current If-Match, got %d", w.Code)
+	}
+}
+
+func TestETagIdentifiesProduct(t *testing.T) {
+	r, db := setupTestRouter(t)
+
+	get := func(path, accept, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	_ = db.Create(&Product{Code: "first", Price: 1})
+	etag := get("/product/latest", "", "").Header().Get("ETag")
+
+	// a newer product at the same version is not the one the client has
+	_ = db.Create(&Product{Code: "second", Price: 1})
+	if w := get("/product/latest", "", etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("expected 200 with a new ETag once another product is latest, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	// each representation has its own tag
+	csv := get("/product/2", "text/csv", "").Header().Get("ETag")
+	if csv != `"2-1-csv"` {
+		t.Fatalf("expected the CSV ETag, got %q", csv)
+	}
+	if w := get("/product/2", "application/json", csv); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for the JSON representation with the CSV ETag, got %d", w.Code)
+	}
+	if w := get("/product/2", "text/csv", csv); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for the CSV representation with its ETag, got %d", w.Code)
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	t.Setenv("REQUIRE_IF_MATCH", "true")
+	r, db := setupTestRouter(t)
+
+	p := Product{Code: "strict", Price: 1}
+	_ = db.Create(&p)
+
+	req := httptest.NewRequest(http.MethodDelete, "/product/"+strconv.FormatUint(uint64(p.ID), 10), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected 428 without If-Match, got %d", w.Code)
+	}
+}
+
+func TestCORSHeaders(t *testing.T) {
+	r, db := setupTestRouter(t)
+	_ = db.Create(&Product{Code: "cors", Price: 1})
+
+	// the admin UI's preflight for a conditional, idempotent write passes
+	req := httptest.NewRequest(http.MethodOptions, "/product/1", nil)
+	req.Header.Set("Origin", "http://localhost:5173")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	req.Header.Set("Access-Control-Request-Headers", "content-type, if-match, idempotency-key, x-request-id")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for the preflight, got %d", w.Code)
+	}
+	allowed := strings.ToLower(w.Header().Get("Access-Control-Allow-Headers"))
+	for _, h := range []string{"if-match", "if-none-match", "idempotency-key", "x-request-id", "traceparent"} {
+		if !strings.Contains(allowed, h) {
+			t.Errorf("expected %s in Access-Control-Allow-Headers %q", h, allowed)
+		}
+	}
+
+	// and it can read the ETag and list headers of the responses
+	req = httptest.NewRequest(http.MethodGet, "/product/1", nil)
+	req.Header.Set("Origin", "http://localhost:5173")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	exposed := strings.ToLower(w.Header().Get("Access-Control-Expose-Headers"))
+	for _, h := range []string{"etag", "x-request-id", "x-total-count", "link", "idempotent-replayed"} {
+		if !strings.Contains(exposed, h) {
+			t.Errorf("expected %s in Access-Control-Expose-Headers %q", h, exposed)
+		}
+	}
+}
+
+func TestPOSTIdempotencyKey(t *testing.T) {
+	r, db := setupTestRouter(t)
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/product", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("retry-1", `{"code":"idem","price":10}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", first.Code)
+	}
+
+	replay := post("retry-1", `{"code":"idem","price":10}`)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Fatalf("expected replayed 201 with identical body, got %d %s", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || replay.Header().Get("Location") != first.Header().Get("Location") {
+		t.Fatalf("expected replay headers, got %v", replay.Header())
+	}
+
+	var count int64
+	db.Model(&Product{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected a single product after retry, got %d", count)
+	}
+
+	conflict := post("retry-1", `{"code":"other","price":10}`)
+	if conflict.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for reused key, got %d", conflict.Code)
+	}
+	env := decodeEnvelope(t, conflict)
+	if code := env["error"].(map[string]interface{})["code"]; code != CodeIdempotencyKeyReused {
+		t.Fatalf("expected %s, got %v", CodeIdempotencyKeyReused, code)
+	}
+
+	// validation failures are stored too, so the same bad request replays
+	bad := post("retry-2", `{"code":"x"}`)
+	again := post("retry-2", `{"code":"x"}`)
+	if bad.Code != http.StatusBadRequest || again.Code != http.StatusBadRequest || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected replayed 400, got %d then %d", bad.Code, again.Code)
+	}
+}
+
+// panickingCreateRepository panics on the first Create and then behaves
+// like the repository it wraps.
+type panickingCreateRepository struct {
+	ProductRepository
+	panicked *bool
+}
+
+func (r panickingCreateRepository) Create(ctx context.Context, code string, price uint) (Product, error) {
+	if !*r.panicked {
+		*r.panicked = true
+		panic("boom")
+	}
+	return r.ProductRepository.Create(ctx, code, price)
+}
+
+func TestPOSTIdempotencyKeyAfterPanic(t *testing.T) {
+	var panicked bool
+	r := newRouter(panickingCreateRepository{NewMemoryProductRepository(), &panicked})
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/product", strings.NewReader(`{"code":"idem","price":10}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "panic-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post(); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 from the panicking handler, got %d: %s", w.Code, w.Body.String())
+	}
+	// the key was released, so the retry runs instead of getting 409
+	if w := post(); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected the retry to create the product, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestProductCodeConflict(t *testing.T) {
+	r, _ := setupTestRouter(t)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(http.MethodPost, "/product", `{"code":"dup","price":1}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if w := send(http.MethodPost, "/product", `{"code":"other","price":1}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+
+	for _, w := range []*httptest.ResponseRecorder{
+		send(http.MethodPost, "/product", `{"code":"dup","price":2}`),
+		send(http.MethodPut, "/product/2", `{"code":"dup","price":2}`),
+	} {
+		if w.Code != http.StatusConflict {
+			t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+		}
+		env := decodeEnvelope(t, w)
+		e := env["error"].(map[string]interface{})
+		if e["code"] != CodeProductCodeConflict || e["details"].(map[string]interface{})["code"] != "dup" {
+			t.Fatalf("unexpected error %v", e)
+		}
+	}
+}
+
+func TestPUTProductByCode(t *testing.T) {
+	r, db := setupTestRouter(t)
+
+	put := func(code, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/product/by-code/"+code, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	created := put("SKU-1", `{"price":10}`)
+	if created.Code != http.StatusCreated || created.Header().Get("Location") != "/product/1" {
+		t.Fatalf("expected 201 with Location, got %d %v", created.Code, created.Header())
+	}
+
+	updated := put("SKU-1", `{"price":20}`)
+	if updated.Code != http.StatusOK || updated.Header().Get("ETag") != `"1-2"` {
+		t.Fatalf("expected 200 with ETag \"1-2\", got %d %v", updated.Code, updated.Header())
+	}
+	data := decodeEnvelope(t, updated)["data"].(map[string]interface{})
+	if data["ID"] != float64(1) || data["Price"] != float64(20) {
+		t.Fatalf("unexpected product %v", data)
+	}
+
+	var count int64
+	db.Model(&Product{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected a single product, got %d", count)
+	}
+
+	if w := put("SKU-1", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without price, got %d", w.Code)
+	}
+}
+
+func TestTrashRestoreAndHardDelete(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	r, db := setupTestRouter(t)
+	db.Create(&Product{Code: "T1", Price: 1})
+
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(http.MethodDelete, "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 on delete, got %d", w.Code)
+	}
+
+	w := send(http.MethodGet, "/products/trash", "")
+	env := decodeEnvelope(t, w)
+	data := env["data"].([]interface{})
+	if w.Code != http.StatusOK || len(data) != 1 || env["meta"].(map[string]interface{})["total"] != float64(1) {
+		t.Fatalf("unexpected trash listing %d: %s", w.Code, w.Body.String())
+	}
+	if data[0].(map[string]interface{})["DeletedAt"] == nil {
+		t.Fatalf("expected DeletedAt in trash listing")
+	}
+
+	if w := send(http.MethodPost, "/product/1/restore", ""); w.Code != http.StatusOK || w.Header().Get("ETag") != `"1-2"` {
+		t.Fatalf("expected 200 with ETag \"1-2\" on restore, got %d %v", w.Code, w.Header())
+	}
+	if w := send(http.MethodGet, "/product/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected restored product to be visible, got %d", w.Code)
+	}
+	if w := send(http.MethodPost, "/product/1/restore", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 restoring a live product, got %d", w.Code)
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		w := send(http.MethodDelete, "/product/1?hard=true", token)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 for token %q, got %d", token, w.Code)
+		}
+		if code := decodeEnvelope(t, w)["error"].(map[string]interface{})["code"]; code != CodeAdminRequired {
+			t.Fatalf("expected %s, got %v", CodeAdminRequired, code)
+		}
+	}
+	if w := send(http.MethodDelete, "/product/1?hard=true", "s3cret"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 on hard delete, got %d", w.Code)
+	}
+	var count int64
+	db.Unscoped().Model(&Product{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected row to be removed, got %d", count)
+	}
+}
+
+func TestPOSTProductsBulk(t *testing.T) {
+	t.Setenv("MAX_BULK_SIZE", "3")
+	r, db := setupTestRouter(t)
+	db.Create(&Product{Code: "EXIST", Price: 1})
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	count := func() int64 {
+		var n int64
+		db.Model(&Product{}).Count(&n)
+		return n
+	}
+
+	// best-effort: the failing item doesn't stop the others
+	w := post("/products/bulk", `[
+		{"op":"create","code":"B1","price":5},
+		{"op":"update","id":99,"price":7},
+		{"op":"delete","id":1}
+	]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	env := decodeEnvelope(t, w)
+	results := env["data"].([]interface{})
+	statuses := []float64{}
+	for _, res := range results {
+		statuses = append(statuses, res.(map[string]interface{})["status"].(float64))
+	}
+	if fmt.Sprint(statuses) != "[201 404 200]" {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+	if code := results[1].(map[string]interface{})["error"].(map[string]interface{})["code"]; code != CodeProductNotFound {
+		t.Fatalf("expected %s for missing product, got %v", CodeProductNotFound, code)
+	}
+	meta := env["meta"].(map[string]interface{})
+	if meta["succeeded"] != float64(2) || meta["failed"] != float64(1) || count() != 1 {
+		t.Fatalf("unexpected meta %v / count %d", meta, count())
+	}
+
+	// atomic: a failure rolls back the whole batch
+	w = post("/products/bulk?atomic=true", `[
+		{"op":"create","code":"A1","price":5},
+		{"op":"create","code":"B1","price":5}
+	]`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", w.Code, w.Body.String())
+	}
+	details := decodeEnvelope(t, w)["error"].(map[string]interface{})["details"].(map[string]interface{})
+	results = details["results"].([]interface{})
+	if details["failed_index"] != float64(1) || results[0].(map[string]interface{})["status"] != float64(http.StatusFailedDependency) ||
+		results[0].(map[string]interface{})["error"].(map[string]interface{})["code"] != CodeBulkOperationNotApplied ||
+		results[1].(map[string]interface{})["error"].(map[string]interface{})["code"] != CodeProductCodeConflict {
+		t.Fatalf("unexpected rollback details %v", details)
+	}
+	if count() != 1 {
+		t.Fatalf("expected atomic batch to be rolled back, got %d products", count())
+	}
+
+	w = post("/products/bulk?atomic=true", `[
+		{"op":"create","code":"A1","price":5},
+		{"op":"update","id":2,"price":9,"version":1}
+	]`)
+	if w.Code != http.StatusOK || count() != 2 {
+		t.Fatalf("expected atomic batch to commit, got %d with %d products", w.Code, count())
+	}
+
+	if w := post("/products/bulk", `[{"op":"delete","id":1},{"op":"delete","id":2},{"op":"delete","id":3},{"op":"delete","id":4}]`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 above MAX_BULK_SIZE, got %d", w.Code)
+	}
+	if w := post("/products/bulk", `[]`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an empty batch, got %d", w.Code)
+	}
+}
+
+func TestPOSTProductsImport(t *testing.T) {
+	r, db := setupTestRouter(t)
+	db.Create(&Product{Code: "KEEP", Price: 5})
+	db.Create(&Product{Code: "BUMP", Price: 5})
+
+	upload := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	summary := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return decodeEnvelope(t, w)["data"].(map[string]interface{})
+	}
+	prices := func() map[string]uint {
+		var products []Product
+		db.Find(&products)
+		out := map[string]uint{}
+		for _, p := range products {
+			out[p.Code] = p.Price
+		}
+		return out
+	}
+
+	csvBody := "Price,Code,Note\n5,KEEP,same\n9,BUMP,\n3,NEW,\nabc,BAD,\n"
+
+	dry := summary(upload("/products/import?dry_run=true", "text/csv", csvBody))
+	if dry["inserted"] != float64(1) || dry["updated"] != float64(1) || dry["skipped"] != float64(2) || dry["dry_run"] != true {
+		t.Fatalf("unexpected dry run summary %v", dry)
+	}
+	if p := prices(); len(p) != 2 || p["BUMP"] != 5 {
+		t.Fatalf("dry run must not write, got %v", p)
+	}
+
+	got := summary(upload("/products/import", "text/csv; charset=utf-8", csvBody))
+	if got["inserted"] != float64(1) || got["updated"] != float64(1) || got["skipped"] != float64(2) {
+		t.Fatalf("unexpected summary %v", got)
+	}
+	errs := got["errors"].([]interface{})
+	if len(errs) != 1 || errs[0].(map[string]interface{})["line"] != float64(5) {
+		t.Fatalf("expected one error on line 5, got %v", errs)
+	}
+	if p := prices(); p["BUMP"] != 9 || p["NEW"] != 3 || p["KEEP"] != 5 {
+		t.Fatalf("unexpected prices after import %v", p)
+	}
+
+	ndjson := "{\"code\":\"NEW\",\"price\":4}\n\n{\"code\":\"NDJ\",\"price\":1}\nnot json\n{\"code\":\"NDJ\",\"price\":2}\n"
+	got = summary(upload("/products/import", "application/x-ndjson", ndjson))
+	if got["inserted"] != float64(1) || got["updated"] != float64(2) || got["skipped"] != float64(1) {
+		t.Fatalf("unexpected ndjson summary %v", got)
+	}
+	if line := got["errors"].([]interface{})[0].(map[string]interface{})["line"]; line != float64(4) {
+		t.Fatalf("expected error on line 4, got %v", line)
+	}
+	if p := prices(); p["NEW"] != 4 || p["NDJ"] != 2 {
+		t.Fatalf("unexpected prices after ndjson import %v", p)
+	}
+
+	if w := upload("/products/import", "text/csv", "sku,cost\nA,1\n"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for missing columns, got %d", w.Code)
+	}
+	if w := upload("/products/import", "application/json", "[]"); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d", w.Code)
+	}
+}
+
+// failingUpsertRepository fails UpsertByCode for one code, inside
+// transactions too.
+type failingUpsertRepository struct {
+	ProductRepository
+	code string
+}
+
+func (r failingUpsertRepository) UpsertByCode(ctx context.Context, code string, price uint) (Product, bool, error) {
+	if code == r.code {
+		return Product{}, false, errors.New("disk full")
+	}
+	return r.ProductRepository.UpsertByCode(ctx, code, price)
+}
+
+func (r failingUpsertRepository) Transaction(ctx context.Context, fn func(tx ProductRepository) error) error {
+	return r.ProductRepository.Transaction(ctx, func(tx ProductRepository) error {
+		return fn(failingUpsertRepository{tx, r.code})
+	})
+}
+
+func TestXLSXExporterStreams(t *testing.T) {
+	var out bytes.Buffer
+	e, err := newXLSXExporter(&out)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+	p := Product{Code: "STREAM", Price: 1, Version: 1}
+	sent := 0
+	for i := 1; i <= 3; i++ {
+		for j := 0; j < 1000; j++ {
+			p.ID = uint(i*1000 + j)
+			if err := e.Write(p); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+		if out.Len() <= sent {
+			t.Fatalf("expected rows to reach the writer on flush %d, still %d bytes", i, out.Len())
+		}
+		sent = out.Len()
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	rows := readXLSXRows(t, out.Bytes())
+	if len(rows) != 3001 || rows[3000][0] != "3999" || rows[3000][1] != "STREAM" {
+		t.Fatalf("unexpected XLSX rows: %d", len(rows))
+	}
+
+	// a failing writer stops the export instead of formatting every row
+	e, err = newXLSXExporter(brokenResponseWriter{httptest.NewRecorder()})
+	if err == nil {
+		for i := 0; i < 100000 && err == nil; i++ {
+			err = e.Write(p)
+		}
+	}
+	if err == nil {
+		t.Fatal("expected the write error to reach the caller")
+	}
+}
+
+// readXLSXRows returns the cell values of the sheet of an XLSX file
+// written by xlsxExporter.
+func readXLSXRows(t *testing.T, data []byte) [][]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to open XLSX: %v", err)
+	}
+	f, err := zr.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatalf("failed to open sheet: %v", err)
+	}
+	defer f.Close()
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Number string `xml:"v"`
+				Text   string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.NewDecoder(f).Decode(&sheet); err != nil {
+		t.Fatalf("failed to decode sheet: %v", err)
+	}
+	rows := make([][]string, len(sheet.Rows))
+	for i, row := range sheet.Rows {
+		for _, cell := range row.Cells {
+			rows[i] = append(rows[i], cell.Number+cell.Text)
+		}
+	}
+	return rows
+}
+
+func TestXLSXColumnName(t *testing.T) {
+	for n, want := range map[int]string{1: "A", 6: "F", 26: "Z", 27: "AA", 52: "AZ", 703: "AAA"} {
+		if got := xlsxColumnName(n); got != want {
+			t.Errorf("xlsxColumnName(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestPOSTProductsImportIncomplete(t *testing.T) {
+	repo := NewMemoryProductRepository()
+	r := newRouter(failingUpsertRepository{repo, "FAIL"})
+
+	// the first batch is written; the second fails on its second row
+	var body strings.Builder
+	for i := 1; i <= importBatchSize+1; i++ {
+		fmt.Fprintf(&body, "{\"code\":\"P%d\",\"price\":1}\n", i)
+	}
+	body.WriteString("{\"code\":\"FAIL\",\"price\":1}\n")
+	req := httptest.NewRequest(http.MethodPost, "/products/import", strings.NewReader(body.String()))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d: %s", w.Code, w.Body.String())
+	}
+	apiErr := decodeEnvelope(t, w)["error"].(map[string]interface{})
+	details := apiErr["details"].(map[string]interface{})
+	if apiErr["code"] != CodeImportIncomplete || details["line"] != float64(importBatchSize+2) || details["resume_from_line"] != float64(importBatchSize+1) {
+		t.Fatalf("expected IMPORT_INCOMPLETE at line %d resuming from %d, got %v", importBatchSize+2, importBatchSize+1, apiErr)
+	}
+	summary := details["summary"].(map[string]interface{})
+	if summary["inserted"] != float64(importBatchSize) || summary["updated"] != float64(0) {
+		t.Fatalf("expected the summary of the first batch, got %v", summary)
+	}
+	if n, _ := repo.Count(context.Background(), ProductQuery{}); n != importBatchSize {
+		t.Fatalf("expected %d products written, got %d", importBatchSize, n)
+	}
+}
+
+func TestGETProductsExport(t *testing.T) {
+	r, db := setupTestRouter(t)
+	for i, price := range []uint{30, 10, 20} {
+		db.Create(&Product{Code: fmt.Sprintf("EXP%d", i+1), Price: price})
+	}
+	db.Create(&Product{Code: "OTHER", Price: 99})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/products/export?format=csv&code[prefix]=exp&sort=price")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected CSV export, got %d %v", w.Code, w.Header())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="products.csv"` {
+		t.Fatalf("unexpected Content-Disposition %q", cd)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 4 || lines[0] != "id,code,price,version,created_at,updated_at" ||
+		!strings.HasPrefix(lines[1], "2,EXP2,10,1,") || !strings.HasPrefix(lines[3], "1,EXP1,30,1,") {
+		t.Fatalf("unexpected CSV export:\n%s", w.Body.String())
+	}
+
+	w = get("/products/export?format=ndjson&price[gte]=20")
+	lines = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 NDJSON lines, got %q", w.Body.String())
+	}
+	var first map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first["code"] != "EXP1" || first["version"] != float64(1) {
+		t.Fatalf("unexpected NDJSON line %q: %v", lines[0], err)
+	}
+
+	w = get("/products/export?format=xlsx")
+	rows := readXLSXRows(t, w.Body.Bytes())
+	if len(rows) != 5 || rows[0][1] != "code" || rows[4][1] != "OTHER" {
+		t.Fatalf("unexpected XLSX rows %v", rows)
+	}
+
+	if w := get("/products/export?format=pdf"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown format, got %d", w.Code)
+	}
+	if w := get("/products/export?sort=nope"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid sort, got %d", w.Code)
+	}
+}
+
+func TestProductsContentNegotiation(t *testing.T) {
+	r, db := setupTestRouter(t)
+	for i := 1; i <= 3; i++ {
+		db.Create(&Product{Code: fmt.Sprintf("N%d", i), Price: uint(i)})
+	}
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// the JSON envelope stays the default
+	if w := get("/products", "*/*"); w.Code != http.StatusOK || decodeEnvelope(t, w)["meta"] == nil {
+		t.Fatalf("expected JSON envelope, got %d %s", w.Code, w.Body.String())
+	}
+
+	w := get("/products?page=2&per_page=1", "text/csv")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected CSV, got %d %v", w.Code, w.Header())
+	}
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "2,N2,2,") {
+		t.Fatalf("unexpected CSV body %q", w.Body.String())
+	}
+	if w.Header().Get("X-Total-Count") != "3" || w.Header().Get("X-Page") != "2" || w.Header().Get("X-Total-Pages") != "3" {
+		t.Fatalf("expected meta headers, got %v", w.Header())
+	}
+	link := w.Header().Get("Link")
+	for _, want := range []string{`page=1&per_page=1>; rel="first"`, `page=1&per_page=1>; rel="prev"`, `page=3&per_page=1>; rel="next"`, `page=3&per_page=1>; rel="last"`} {
+		if !strings.Contains(link, want) {
+			t.Fatalf("expected Link to contain %q, got %q", want, link)
+		}
+	}
+
+	w = get("/products?limit=2", "application/x-ndjson")
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"code":"N1"`) {
+		t.Fatalf("unexpected NDJSON body %q", w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Link"), `rel="next"`) || w.Header().Get("X-Next-Cursor") == "" {
+		t.Fatalf("expected cursor headers, got %v", w.Header())
+	}
+
+	w = get("/products", "application/xml")
+	var list struct {
+		Products []productRecord `xml:"product"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Products) != 3 || list.Products[2].Code != "N3" {
+		t.Fatalf("unexpected XML body %q: %v", w.Body.String(), err)
+	}
+
+	w = get("/product/2", "application/msgpack")
+	var rec map[string]interface{}
+	mh := codec.MsgpackHandle{}
+	mh.RawToString = true
+	if err := codec.NewDecoderBytes(w.Body.Bytes(), &mh).Decode(&rec); err != nil || rec["code"] != "N2" {
+		t.Fatalf("unexpected msgpack body %v: %v", rec, err)
+	}
+	if w.Header().Get("ETag") != `"2-1-msgpack"` {
+		t.Fatalf("expected the msgpack ETag on negotiated detail response, got %v", w.Header())
+	}
+
+	w = get("/product/2", "text/html")
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d", w.Code)
+	}
+	if code := decodeEnvelope(t, w)["error"].(map[string]interface{})["code"]; code != CodeNotAcceptable {
+		t.Fatalf("expected %s, got %v", CodeNotAcceptable, code)
+	}
+}
+
+// brokenResponseWriter is a response whose client went away: every body
+// write fails.
+type brokenResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w brokenResponseWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestProductsNegotiatedWriteErrorsAreLogged(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(&buf, "info"))
+
+	r, db := setupTestRouter(t)
+	_ = db.Create(&Product{Code: "N1", Price: 1})
+
+	for _, accept := range []string{"text/csv", "application/x-ndjson"} {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/products", nil)
+		req.Header.Set("Accept", accept)
+		r.ServeHTTP(brokenResponseWriter{httptest.NewRecorder()}, req)
+		if !strings.Contains(buf.String(), `"msg":"products: response aborted"`) || !strings.Contains(buf.String(), "broken pipe") {
+			t.Fatalf("%s: expected the write error to be logged, got %s", accept, buf.String())
+		}
+	}
+}
+
+func TestInternalErrorsAreNotLeaked(t *testing.T) {
+	r, db := setupTestRouter(t)
+	if err := db.Exec("DROP TABLE products").Error; err != nil {
+		t.Fatalf("failed to drop table: %v", err)
+	}
+	get := func() map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, "/product/1", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected 500, got %d: %s", w.Code, w.Body.String())
+		}
+		apiErr := decodeEnvelope(t, w)["error"].(map[string]interface{})
+		if apiErr["code"] != CodeInternalError || apiErr["message"] != ErrorMessages[CodeInternalError] {
+			t.Fatalf("unexpected error %v", apiErr)
+		}
+		details := apiErr["details"].(map[string]interface{})
+		if id, _ := details["correlation_id"].(string); len(id) != 16 {
+			t.Fatalf("expected a correlation ID, got %v", details)
+		}
+		return details
+	}
+
+	// outside release mode the cause is included for debugging
+	if cause, _ := get()["cause"].(string); !strings.Contains(cause, "products") {
+		t.Fatalf("expected the cause in debug mode, got %q", cause)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(gin.TestMode)
+	if details := get(); len(details) != 1 {
+		t.Fatalf("expected only the correlation ID in release mode, got %v", details)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	db := openTestDB(t)
+	failing := HealthCheck{Name: "cache", Check: func(ctx context.Context) (map[string]interface{}, error) {
+		return nil, errors.New("cache unreachable")
+	}}
+	get := func(r *gin.Engine, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	checks := func(report map[string]interface{}) map[string]map[string]interface{} {
+		out := map[string]map[string]interface{}{}
+		for _, c := range report["checks"].([]interface{}) {
+			check := c.(map[string]interface{})
+			out[check["name"].(string)] = check
+		}
+		return out
+	}
+
+	r := newRouter(NewGormProductRepository(db), WithHealthCheck(databaseCheck(db)), WithHealthCheck(migrationsCheck(db)), WithHealthCheck(failing))
+	if w := get(r, "/healthz"); w.Code != http.StatusOK {
+		t.Fatalf("expected healthz 200, got %d", w.Code)
+	}
+
+	// a failing non-critical check is reported without failing readiness
+	w := get(r, "/readyz")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected readyz 200, got %d: %s", w.Code, w.Body.String())
+	}
+	report := decodeEnvelope(t, w)["data"].(map[string]interface{})
+	got := checks(report)
+	if report["status"] != "ready" || got["database"]["status"] != "pass" || got["migrations"]["status"] != "pass" || got["cache"]["status"] != "fail" {
+		t.Fatalf("unexpected report %v", report)
+	}
+	if pool := got["database"]["details"].(map[string]interface{}); pool["driver"] != "sqlite" {
+		t.Fatalf("expected pool stats, got %v", pool)
+	}
+	if migs := got["migrations"]["details"].(map[string]interface{}); migs["pending"] != float64(0) {
+		t.Fatalf("expected no pending migrations, got %v", migs)
+	}
+
+	// a failing critical check fails readiness, with the report in details
+	failing.Critical = true
+	r = newRouter(NewGormProductRepository(db), WithHealthCheck(databaseCheck(db)), WithHealthCheck(failing))
+	w = get(r, "/readyz")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readyz 503, got %d: %s", w.Code, w.Body.String())
+	}
+	apiErr := decodeEnvelope(t, w)["error"].(map[string]interface{})
+	report = apiErr["details"].(map[string]interface{})
+	if apiErr["code"] != CodeNotReady || report["status"] != "not_ready" || checks(report)["cache"]["error"] != "cache unreachable" {
+		t.Fatalf("unexpected error %v", apiErr)
+	}
+
+	// an unmigrated database is not ready, and probing it changes nothing
+	fresh, err := dbconn.Open("sqlite::memory:", &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	r = newRouter(NewGormProductRepository(fresh), WithHealthCheck(migrationsCheck(fresh)))
+	if w := get(r, "/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readyz 503 with pending migrations, got %d", w.Code)
+	}
+	if fresh.Migrator().HasTable("schema_migrations") {
+		t.Fatal("readiness probes must not create schema_migrations")
+	}
+
+	// a closed database pool fails the database check
+	sqlDB, _ := db.DB()
+	_ = sqlDB.Close()
+	r = newRouter(NewGormProductRepository(db), WithHealthCheck(databaseCheck(db)))
+	if w := get(r, "/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readyz 503 with the database down, got %d", w.Code)
+	}
+}
+
+func TestGETMetrics(t *testing.T) {
+	db := openTestDB(t)
+	metrics := NewMetrics()
+	if err := metrics.InstrumentDB(db); err != nil {
+		t.Fatalf("failed to instrument db: %v", err)
+	}
+	r := newRouter(NewGormProductRepository(db), WithMetrics(metrics))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	for _, code := range []string{"A", "B", "C"} {
+		_ = db.Create(&Product{Code: code, Price: 1})
+	}
+	db.Delete(&Product{}, 3)
+	get("/product/1")
+	get("/product/2")
+	get("/product/42")
+	get("/no/such/route")
+	r.GET("/panic", func(*gin.Context) { panic("boom") })
+	get("/panic")
+
+	w := get("/metrics")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`may_http_requests_total{method="GET",route="/product/:id",status="200"} 2`,
+		`may_http_requests_total{method="GET",route="/product/:id",status="404"} 1`,
+		`may_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`may_http_requests_total{method="GET",route="/panic",status="500"} 1`,
+		`may_http_request_duration_seconds_count{method="GET",route="/panic",status="500"} 1`,
+		`may_http_request_duration_seconds_count{method="GET",route="/product/:id",status="200"} 2`,
+		`may_db_query_duration_seconds_count{operation="create"} 3`,
+		`may_db_query_duration_seconds_count{operation="query"}`,
+		`go_sql_open_connections{db_name="sqlite"}`,
+		"may_products_active 2",
+		"may_products_deleted 1",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+	if strings.Contains(body, `route="/product/1"`) {
+		t.Error("raw paths must not be used as labels")
+	}
+}
+
+func TestRequestIDAndLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "debug")
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	db, err := dbconn.Open("sqlite::memory:", &gorm.Config{Logger: newGormLogger(logger, 0)})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if err := runMigrations(db); err != nil {
+		t.Fatalf("migrations failed: %v", err)
+	}
+	r := newRouter(NewGormProductRepository(db))
+	get := func(requestID, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/product/42", nil)
+		if requestID != "" {
+			req.Header.Set(requestIDHeader, requestID)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// a valid incoming ID is echoed, in the header and the envelope
+	buf.Reset()
+	w := get("req-123", "")
+	if w.Header().Get(requestIDHeader) != "req-123" || decodeEnvelope(t, w)["request_id"] != "req-123" {
+		t.Fatalf("expected request ID req-123, got %q / %s", w.Header().Get(requestIDHeader), w.Body.String())
+	}
+
+	// every log record of the request carries it, GORM's included
+	messages := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		if rec["request_id"] != "req-123" {
+			t.Fatalf("expected request_id in %v", rec)
+		}
+		messages[rec["msg"].(string)] = true
+	}
+	if !messages["sql"] || !messages["request"] {
+		t.Fatalf("expected sql and request records, got %v", messages)
+	}
+
+	// missing or malformed IDs are replaced with generated ones
+	for _, id := range []string{"", "bad id\n", strings.Repeat("x", 200)} {
+		w := get(id, "")
+		got := w.Header().Get(requestIDHeader)
+		if got == id || len(got) != 16 || decodeEnvelope(t, w)["request_id"] != got {
+			t.Fatalf("expected a generated request ID for %q, got %q", id, got)
+		}
+	}
+
+	var problem map[string]interface{}
+	if err := json.Unmarshal(get("req-456", mediaTypeProblem+", application/json;q=0.5").Body.Bytes(), &problem); err != nil {
+		t.Fatalf("failed to decode problem: %v", err)
+	}
+	if problem["request_id"] != "req-456" {
+		t.Fatalf("expected request_id in problem details, got %v", problem)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	db := openTestDB(t)
+	if err := InstrumentDBTracing(db, tp); err != nil {
+		t.Fatalf("failed to instrument db: %v", err)
+	}
+	r := newRouter(NewGormProductRepository(db), WithTracerProvider(tp))
+	db.Create(&Product{Code: "A", Price: 1})
+	if n := len(exporter.GetSpans()); n != 0 {
+		t.Fatalf("expected statements outside a trace not to be traced, got %d spans", n)
+	}
+	byName := func() map[string]tracetest.SpanStub {
+		spans := map[string]tracetest.SpanStub{}
+		for _, s := range exporter.GetSpans() {
+			spans[s.Name] = s
+		}
+		return spans
+	}
+
+	// an incoming traceparent is continued: request > DAL call > SQL
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/product/1", nil)
+	req.Header.Set("traceparent", traceparent)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	spans := byName()
+	server, ok := spans["GET /product/:id"]
+	if !ok {
+		t.Fatalf("expected a server span, got %v", spans)
+	}
+	if server.SpanKind != trace.SpanKindServer ||
+		server.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("expected the server span to continue the incoming trace, got %+v", server)
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range server.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["http.route"].AsString() != "/product/:id" || attrs["http.response.status_code"].AsInt64() != 200 {
+		t.Fatalf("unexpected server span attributes %v", attrs)
+	}
+	dal := spans["ProductRepository.GetByID"]
+	if dal.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Fatalf("expected the DAL span to be a child of the server span, got %+v", dal)
+	}
+	sql := spans["gorm.query"]
+	if sql.Parent.SpanID() != dal.SpanContext.SpanID() || sql.SpanKind != trace.SpanKindClient {
+		t.Fatalf("expected the SQL span to be a client child of the DAL span, got %+v", sql)
+	}
+	for _, kv := range sql.Attributes {
+		if kv.Key == "db.query.text" && !strings.Contains(kv.Value.AsString(), "SELECT") {
+			t.Fatalf("unexpected db.query.text %q", kv.Value.AsString())
+		}
+	}
+
+	// calls inside a transaction are children of its span
+	exporter.Reset()
+	req = httptest.NewRequest(http.MethodPost, "/products/bulk?atomic=true", strings.NewReader(`[{"op":"create","code":"B","price":2}]`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	spans = byName()
+	tx, create := spans["ProductRepository.Transaction"], spans["ProductRepository.Create"]
+	if create.Parent.SpanID() != tx.SpanContext.SpanID() || tx.Parent.SpanID() != spans["POST /products/bulk"].SpanContext.SpanID() {
+		t.Fatalf("expected request > Transaction > Create, got %v", spans)
+	}
+	if spans["gorm.create"].Parent.SpanID() != create.SpanContext.SpanID() {
+		t.Fatalf("expected the INSERT to be a child of Create, got %+v", spans["gorm.create"])
+	}
+
+	// server errors fail the span; missing products don't fail the DAL span
+	exporter.Reset()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/product/42", nil))
+	spans = byName()
+	if spans["GET /product/:id"].Status.Code != otelcodes.Unset || spans["ProductRepository.GetByID"].Status.Code != otelcodes.Unset {
+		t.Fatalf("expected a 404 not to be an error, got %v", spans)
+	}
+	exporter.Reset()
+	db.Exec("DROP TABLE products")
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/product/1", nil))
+	spans = byName()
+	if spans["GET /product/:id"].Status.Code != otelcodes.Error || spans["ProductRepository.GetByID"].Status.Code != otelcodes.Error {
+		t.Fatalf("expected a 500 to fail the spans, got %v", spans)
+	}
+}
+
+func TestGeneratedProductTypeMatchesJSON(t *testing.T) {
+	src, err := os.ReadFile("../frontend/src/apiTypes.ts")
+	if err != nil {
+		t.Skipf("frontend sources not available: %v", err)
+	}
+	m := regexp.MustCompile(`(?s)export interface Product \{(.*?)\}`).FindSubmatch(src)
+	if m == nil {
+		t.Fatal("apiTypes.ts has no Product interface")
+	}
+	generated := map[string]bool{}
+	for _, f := range regexp.MustCompile(`(?m)^\s*([A-Za-z_]+):`).FindAllSubmatch(m[1], -1) {
+		generated[string(f[1])] = true
+	}
+
+	data, _ := json.Marshal(Product{})
+	var sent map[string]interface{}
+	_ = json.Unmarshal(data, &sent)
+	for key := range sent {
+		if !generated[key] {
+			t.Errorf("Product JSON key %q is missing from apiTypes.ts (regenerate with cmd/genfrontend)", key)
+		}
+		delete(generated, key)
+	}
+	for key := range generated {
+		t.Errorf("apiTypes.ts declares %q, which the API doesn't send", key)
+	}
+}
+
+func TestGETErrors(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	req := httptest.NewRequest(http.MethodGet, "/errors", nil)
+	req.Header.Set("Accept-Language", "th")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var env struct {
+		Data []ErrorCode `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(env.Data) != len(ErrorRegistry) {
+		t.Fatalf("expected %d codes, got %d", len(ErrorRegistry), len(env.Data))
+	}
+	for i, ec := range env.Data {
+		if i > 0 && env.Data[i-1].Code >= ec.Code {
+			t.Fatalf("codes not sorted: %s before %s", env.Data[i-1].Code, ec.Code)
+		}
+		want := ErrorRegistry[ec.Code]
+		if ec.Status != want.Status || ec.Retryable != want.Retryable || ec.DocURL != want.DocURL {
+			t.Fatalf("expected %+v, got %+v", want, ec)
+		}
+		if ec.Code == CodeProductNotFound && ec.Message != errorCatalogs["th"][CodeProductNotFound] {
+			t.Fatalf("expected localized message, got %q", ec.Message)
+		}
+	}
+}
+
+func TestLocalizedErrorMessages(t *testing.T) {
+	r, _ := setupTestRouter(t)
+	get := func(acceptLanguage, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/product/42", nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	cases := []struct {
+		name, acceptLanguage, want string
+	}{
+		{"no header", "", ErrorMessages[CodeProductNotFound]},
+		{"exact", "th", errorCatalogs["th"][CodeProductNotFound]},
+		{"region falls back to language", "es-MX", errorCatalogs["es"][CodeProductNotFound]},
+		{"skips untranslated", "fr, th;q=0.5", errorCatalogs["th"][CodeProductNotFound]},
+		{"falls back to english", "fr-CA", ErrorMessages[CodeProductNotFound]},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := decodeEnvelope(t, get(tc.acceptLanguage, ""))
+			apiErr := env["error"].(map[string]interface{})
+			if apiErr["message"] != tc.want || apiErr["code"] != CodeProductNotFound {
+				t.Fatalf("expected message %q, got %v", tc.want, apiErr)
+			}
+		})
+	}
+
+	// problem details carry the localized message as detail
+	var body map[string]interface{}
+	if err := json.Unmarshal(get("th", mediaTypeProblem+", application/json;q=0.5").Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode problem: %v", err)
+	}
+	if body["detail"] != errorCatalogs["th"][CodeProductNotFound] {
+		t.Fatalf("expected localized detail, got %v", body)
+	}
+}
+
+func TestProblemDetailsErrors(t *testing.T) {
+	get := func(r *gin.Engine, path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	problem := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Fatalf("expected problem+json, got %q: %s", ct, w.Body.String())
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("failed to decode problem: %v", err)
+		}
+		return body
+	}
+
+	r, _ := setupTestRouter(t)
+
+	// the envelope stays the default
+	if env := decodeEnvelope(t, get(r, "/product/42", "")); env["success"] != false {
+		t.Fatalf("expected envelope, got %v", env)
+	}
+
+	body := problem(get(r, "/product/42", "application/problem+json, application/json;q=0.5"))
+	want := map[string]interface{}{
+		"type":     ErrorRegistry[CodeProductNotFound].DocURL,
+		"title":    "Not Found",
+		"status":   float64(404),
+		"detail":   ErrorMessages[CodeProductNotFound],
+		"instance": "/product/42",
+		"code":     CodeProductNotFound,
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Fatalf("expected %s=%v, got %v", k, v, body)
+		}
+	}
+
+	body = problem(get(r, "/products?sort=nope", "application/problem+json, application/json;q=0.9"))
+	if body["code"] != CodeInvalidFilter || body["details"].(map[string]interface{})["parameter"] != "sort" {
+		t.Fatalf("expected details extension, got %v", body)
+	}
+
+	// with ERROR_FORMAT=problem, clients must ask for the envelope
+	t.Setenv("ERROR_FORMAT", "problem")
+	r, _ = setupTestRouter(t)
+	problem(get(r, "/product/42", ""))
+	problem(get(r, "/product/42", "*/*"))
+	if env := decodeEnvelope(t, get(r, "/product/42", "application/json")); env["success"] != false {
+		t.Fatalf("expected envelope when asked for, got %v", env)
+	}
+}
+
+func TestProductValidationErrors(t *testing.T) {
+	r, _ := setupTestRouter(t)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	fieldErrs := func(w *httptest.ResponseRecorder) map[string]map[string]interface{} {
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+		}
+		e := decodeEnvelope(t, w)["error"].(map[string]interface{})
+		if e["code"] != CodeValidationFailed {
+			t.Fatalf("expected %s, got %v", CodeValidationFailed, e["code"])
+		}
+		out := map[string]map[string]interface{}{}
+		for _, d := range e["details"].([]interface{}) {
+			fe := d.(map[string]interface{})
+			out[fe["field"].(string)] = fe
+		}
+		return out
+	}
+
+	errs := fieldErrs(send(http.MethodPost, "/product", `{"price": 2000000000}`))
+	if errs["code"]["rule"] != "required" || errs["code"]["message"] != "code is required" {
+		t.Fatalf("unexpected code error %v", errs["code"])
+	}
+	if errs["price"]["rule"] != "max" || errs["price"]["param"] != "1000000000" {
+		t.Fatalf("unexpected price error %v", errs["price"])
+	}
+
+	errs = fieldErrs(send(http.MethodPost, "/product", `{"code": "`+strings.Repeat("x", 65)+`", "price": 1}`))
+	if len(errs) != 1 || errs["code"]["rule"] != "max" || errs["code"]["message"] != "code must be at most 64 characters long" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	errs = fieldErrs(send(http.MethodPut, "/product/by-code/-bad", `{"price": 1}`))
+	if len(errs) != 1 || errs["code"]["rule"] != "productcode" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	// malformed JSON isn't a validation error
+	w := send(http.MethodPost, "/product", `{"code": "ok", "price": "1"}`)
+	if code := decodeEnvelope(t, w)["error"].(map[string]interface{})["code"]; w.Code != http.StatusBadRequest || code != CodeInvalidRequest {
+		t.Fatalf("expected 400 %s, got %d %v", CodeInvalidRequest, w.Code, code)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// metricsNamespace prefixes the application's own metrics.
+const metricsNamespace = "may"
+
+// metricsTimeout bounds the database queries behind the business gauges
+// at scrape time.
+const metricsTimeout = 2 * time.Second
+
+// Metrics holds the Prometheus registry served at GET /metrics. Each
+// router gets its own registry, so tests can build routers freely.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+}
+
+// NewMetrics creates a registry with the HTTP metrics and the Go runtime
+// and process collectors.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route template and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method, route template and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "GORM statement latency by operation.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.queryDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// middleware records every request under its route template (e.g.
+// `/product/:id`), or "unmatched" for requests no route handles, so raw
+// paths never become labels.
+func (m *Metrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		m.requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.requestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// registerProductGauges adds gauges of the active and trashed products,
+// counted through repo at scrape time.
+func (m *Metrics) registerProductGauges(repo ProductRepository) {
+	gauge := func(name, help string, count func(ctx context.Context) (int64, error)) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: metricsNamespace, Name: name, Help: help}, func() float64 {
+			ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+			defer cancel()
+			n, err := count(ctx)
+			if err != nil {
+				slog.Error("metrics: counting products failed", slog.String("metric", name), slog.String("error", err.Error()))
+				return 0
+			}
+			return float64(n)
+		})
+	}
+	m.registry.MustRegister(
+		gauge("products_active", "Products that are not deleted.", func(ctx context.Context) (int64, error) {
+			return repo.Count(ctx, ProductQuery{})
+		}),
+		gauge("products_deleted", "Soft-deleted products waiting in the trash.", func(ctx context.Context) (int64, error) {
+			_, total, err := repo.ListDeleted(ctx, 1, 1)
+			return total, err
+		}),
+	)
+}
+
+// metricsStartKey is the gorm instance key holding a statement's start
+// time.
+const metricsStartKey = "metrics:start"
+
+// InstrumentDB times every GORM statement per operation (create, query,
+// update, delete, row, raw) and exports the sql.DBStats pool gauges of db.
+func (m *Metrics) InstrumentDB(db *gorm.DB) error {
+	before := func(string) func(*gorm.DB) {
+		return func(tx *gorm.DB) { tx.InstanceSet(metricsStartKey, time.Now()) }
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if v, ok := tx.InstanceGet(metricsStartKey); ok {
+				m.queryDuration.WithLabelValues(operation).Observe(time.Since(v.(time.Time)).Seconds())
+			}
+		}
+	}
+	if err := registerStatementCallbacks(db, "metrics", before, after); err != nil {
+		return err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return m.registry.Register(collectors.NewDBStatsCollector(sqlDB, db.Dialector.Name()))
+}
+
+// registerStatementCallbacks registers before and after, built per
+// operation, around every kind of GORM statement under names prefixed
+// with name.
+func registerStatementCallbacks(db *gorm.DB, name string, before, after func(operation string) func(*gorm.DB)) error {
+	cb := db.Callback()
+	hooks := []struct {
+		operation string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+	for _, h := range hooks {
+		if err := h.before(name+":before_"+h.operation, before(h.operation)); err != nil {
+			return err
+		}
+		if err := h.after(name+":after_"+h.operation, after(h.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+// Package migrate applies ordered, versioned SQL migrations and records
+// them in a `schema_migrations` table. It works with any GORM dialector;
+// scripts may be specialised per dialect (see the migrations package).
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a single versioned schema change with its up and down
+// scripts resolved for one dialect. Check is an optional query run before
+// Up: any row it returns stops the migration, so data the change can't
+// handle is reported instead of failing halfway through a deploy.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	Check   string
+}
+
+// Status reports whether a migration has been applied.
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// schemaMigration is a row of the tracking table.
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+// fileRe matches `<version>_<name>.<up|down|check>[.<dialect>].sql`.
+var fileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down|check)(?:\.([a-z0-9]+))?\.sql$`)
+
+// maxCheckRows bounds the rows of a failed check quoted in its error.
+const maxCheckRows = 20
+
+// Load reads the migrations in fsys and resolves their scripts for dialect,
+// preferring dialect-specific files over generic ones. Migrations are
+// returned in ascending version order.
+func Load(fsys fs.FS, dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	type scripts struct {
+		name                                 string
+		up, down, check                      string
+		upDialect, downDialect, checkDialect bool
+	}
+	byVersion := map[int64]*scripts{}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m := fileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		if m[4] != "" && m[4] != dialect {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", e.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		s, ok := byVersion[version]
+		if !ok {
+			s = &scripts{name: m[2]}
+			byVersion[version] = s
+		}
+		if s.name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, s.name, m[2])
+		}
+
+		specific := m[4] != ""
+		switch m[3] {
+		case "up":
+			if specific || !s.upDialect {
+				s.up, s.upDialect = string(body), specific
+			}
+		case "down":
+			if specific || !s.downDialect {
+				s.down, s.downDialect = string(body), specific
+			}
+		case "check":
+			if specific || !s.checkDialect {
+				s.check, s.checkDialect = string(body), specific
+			}
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version, s := range byVersion {
+		if s.up == "" || s.down == "" {
+			return nil, fmt.Errorf("migration %d_%s is missing an up or down script for dialect %q", version, s.name, dialect)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: s.name, Up: s.up, Down: s.down, Check: s.check})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New loads the migrations in fsys for db's dialect and ensures the
+// tracking table exists.
+func New(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys, db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// applied returns the recorded migrations keyed by version.
+func (m *Migrator) applied(ctx context.Context) (map[int64]schemaMigration, error) {
+	var rows []schemaMigration
+	if err := m.db.WithContext(ctx).Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[int64]schemaMigration, len(rows))
+	for _, r := range rows {
+		out[r.Version] = r
+	}
+	return out, nil
+}
+
+// Up applies every pending migration in version order, each in its own
+// transaction after its check passes, and returns the ones applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := check(tx, mig.Check); err != nil {
+				return err
+			}
+			if err := tx.Exec(mig.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s up: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// check runs a migration's check query and fails with the rows it
+// returns, listed as `column=value`, after the description given by the
+// script's leading comment.
+func check(tx *gorm.DB, query string) error {
+	if strings.TrimSpace(query) == "" {
+		return nil
+	}
+	// a single statement: drivers reject anything after its semicolon
+	rows, err := tx.Raw(strings.TrimRight(strings.TrimSpace(query), ";")).Rows()
+	if err != nil {
+		return fmt.Errorf("check: %w", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("check: %w", err)
+	}
+
+	var found []string
+	total := 0
+	for rows.Next() {
+		total++
+		if len(found) == maxCheckRows {
+			continue
+		}
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return fmt.Errorf("check: %w", err)
+		}
+		fields := make([]string, len(columns))
+		for i, col := range columns {
+			v := values[i]
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			fields[i] = fmt.Sprintf("%s=%v", col, v)
+		}
+		found = append(found, strings.Join(fields, " "))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("check: %w", err)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	msg := "check failed"
+	var comment []string
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		comment = append(comment, strings.TrimSpace(strings.TrimPrefix(line, "--")))
+	}
+	if len(comment) > 0 {
+		msg = strings.Join(comment, " ")
+	}
+	if total > len(found) {
+		found = append(found, fmt.Sprintf("and %d more", total-len(found)))
+	}
+	return fmt.Errorf("%s: %s", msg, strings.Join(found, "; "))
+}
+
+// Down rolls back the n most recently applied migrations, newest first,
+// and returns the ones rolled back.
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int64, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if n < len(versions) {
+		versions = versions[:n]
+	}
+
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = mig
+	}
+
+	var done []Migration
+	for _, v := range versions {
+		mig, ok := known[v]
+		if !ok {
+			return done, fmt.Errorf("migration %d is applied but has no scripts", v)
+		}
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(mig.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, mig.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s down: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.statuses(applied), nil
+}
+
+// ReadStatus lists the migrations in fsys and whether each has been
+// applied to db, like Status, but only reads: it doesn't create the
+// tracking table as New does, and reports every migration as pending
+// while the table is missing. It suits callers such as health checks
+// whose database user may lack DDL privileges.
+func ReadStatus(ctx context.Context, db *gorm.DB, fsys fs.FS) ([]Status, error) {
+	migrations, err := Load(fsys, db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	m := &Migrator{db: db, migrations: migrations}
+	applied := map[int64]schemaMigration{}
+	if db.WithContext(ctx).Migrator().HasTable(&schemaMigration{}) {
+		if applied, err = m.applied(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return m.statuses(applied), nil
+}
+
+// statuses reports every known migration against the applied ones.
+func (m *Migrator) statuses(applied map[int64]schemaMigration) []Status {
+	out := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Version: mig.Version, Name: mig.Name}
+		if row, ok := applied[mig.Version]; ok {
+			at := row.AppliedAt
+			s.Applied = true
+			s.AppliedAt = &at
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Create writes an empty up/down pair for name into dir, numbered one past
+// the highest existing version, and returns the paths written.
+func Create(dir string, name string) (string, string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(name, "_")
+	name = strings.Trim(name, "_")
+	if name == "" {
+		return "", "", fmt.Errorf("migration name is required")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	var next int64 = 1
+	for _, e := range entries {
+		if m := fileRe.FindStringSubmatch(e.Name()); m != nil {
+			if v, err := strconv.ParseInt(m[1], 10, 64); err == nil && v >= next {
+				next = v + 1
+			}
+		}
+	}
+
+	base := fmt.Sprintf("%04d_%s", next, name)
+	up := filepath.Join(dir, base+".up.sql")
+	down := filepath.Join(dir, base+".down.sql")
+	if err := os.WriteFile(up, []byte("-- "+base+" up\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(down, []byte("-- "+base+" down\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
@@ -0,0 +1,211 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func openDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open in-memory db: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db
+}
+
+var testFS = fstest.MapFS{
+	"0001_widgets.up.sql":          {Data: []byte("CREATE TABLE widgets (id INTEGER);")},
+	"0001_widgets.up.sqlite.sql":   {Data: []byte("CREATE TABLE widgets (id INTEGER PRIMARY KEY);")},
+	"0001_widgets.up.postgres.sql": {Data: []byte("CREATE TABLE widgets (id BIGSERIAL PRIMARY KEY);")},
+	"0001_widgets.down.sql":        {Data: []byte("DROP TABLE widgets;")},
+	"0002_gadgets.up.sql":          {Data: []byte("CREATE TABLE gadgets (id INTEGER);\nCREATE INDEX idx_gadgets_id ON gadgets (id);")},
+	"0002_gadgets.down.sql":        {Data: []byte("DROP TABLE gadgets;")},
+	"README.md":                    {Data: []byte("ignored")},
+}
+
+func TestLoadPrefersDialectScripts(t *testing.T) {
+	migrations, err := Load(testFS, "sqlite")
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Version != 2 {
+		t.Fatalf("unexpected migrations: %+v", migrations)
+	}
+	if migrations[0].Up != "CREATE TABLE widgets (id INTEGER PRIMARY KEY);" {
+		t.Fatalf("expected sqlite-specific up script, got %q", migrations[0].Up)
+	}
+
+	migrations, err = Load(testFS, "mysql")
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if migrations[0].Up != "CREATE TABLE widgets (id INTEGER);" {
+		t.Fatalf("expected generic up script, got %q", migrations[0].Up)
+	}
+}
+
+func TestLoadRequiresDownScript(t *testing.T) {
+	fsys := fstest.MapFS{"0001_x.up.sql": {Data: []byte("SELECT 1;")}}
+	if _, err := Load(fsys, "sqlite"); err == nil {
+		t.Fatalf("expected error for migration without a down script")
+	}
+}
+
+func TestUpDownStatus(t *testing.T) {
+	db := openDB(t)
+	ctx := context.Background()
+
+	m, err := New(db, testFS)
+	if err != nil {
+		t.Fatalf("new failed: %v", err)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil || len(applied) != 2 {
+		t.Fatalf("expected 2 migrations applied, got %d, %v", len(applied), err)
+	}
+	if !db.Migrator().HasTable("widgets") || !db.Migrator().HasTable("gadgets") {
+		t.Fatalf("expected tables to exist after up")
+	}
+
+	// applying again is a no-op
+	applied, err = m.Up(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("expected no migrations applied, got %d, %v", len(applied), err)
+	}
+
+	reverted, err := m.Down(ctx, 1)
+	if err != nil || len(reverted) != 1 || reverted[0].Version != 2 {
+		t.Fatalf("expected migration 2 reverted, got %+v, %v", reverted, err)
+	}
+	if db.Migrator().HasTable("gadgets") {
+		t.Fatalf("expected gadgets table dropped after down")
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if len(statuses) != 2 || !statuses[0].Applied || statuses[0].AppliedAt == nil || statuses[1].Applied {
+		t.Fatalf("unexpected status: %+v", statuses)
+	}
+
+	// down past the first migration only reverts what is applied
+	reverted, err = m.Down(ctx, 5)
+	if err != nil || len(reverted) != 1 || reverted[0].Version != 1 {
+		t.Fatalf("expected migration 1 reverted, got %+v, %v", reverted, err)
+	}
+}
+
+func TestUpStopsOnFailure(t *testing.T) {
+	db := openDB(t)
+	fsys := fstest.MapFS{
+		"0001_ok.up.sql":      {Data: []byte("CREATE TABLE ok (id INTEGER);")},
+		"0001_ok.down.sql":    {Data: []byte("DROP TABLE ok;")},
+		"0002_bad.up.sql":     {Data: []byte("CREATE TABLE ok (id INTEGER);")},
+		"0002_bad.down.sql":   {Data: []byte("SELECT 1;")},
+		"0003_never.up.sql":   {Data: []byte("CREATE TABLE never (id INTEGER);")},
+		"0003_never.down.sql": {Data: []byte("DROP TABLE never;")},
+	}
+
+	m, err := New(db, fsys)
+	if err != nil {
+		t.Fatalf("new failed: %v", err)
+	}
+	applied, err := m.Up(context.Background())
+	if err == nil {
+		t.Fatalf("expected failing migration to return an error")
+	}
+	if len(applied) != 1 || applied[0].Version != 1 {
+		t.Fatalf("expected only migration 1 applied, got %+v", applied)
+	}
+	if db.Migrator().HasTable("never") {
+		t.Fatalf("migrations after a failure must not run")
+	}
+}
+
+func TestUpRunsChecks(t *testing.T) {
+	db := openDB(t)
+	fsys := fstest.MapFS{
+		"0001_tags.up.sql":      {Data: []byte("CREATE TABLE tags (name TEXT); INSERT INTO tags VALUES ('a'), ('a'), ('b');")},
+		"0001_tags.down.sql":    {Data: []byte("DROP TABLE tags;")},
+		"0002_unique.up.sql":    {Data: []byte("CREATE UNIQUE INDEX idx_tags_name ON tags (name);")},
+		"0002_unique.down.sql":  {Data: []byte("DROP INDEX idx_tags_name;")},
+		"0002_unique.check.sql": {Data: []byte("-- Tags are duplicated\nSELECT name, COUNT(*) AS n FROM tags GROUP BY name HAVING COUNT(*) > 1;\n")},
+		"0003_after.up.sql":     {Data: []byte("CREATE TABLE after (id INTEGER);")},
+		"0003_after.down.sql":   {Data: []byte("DROP TABLE after;")},
+	}
+
+	m, err := New(db, fsys)
+	if err != nil {
+		t.Fatalf("new failed: %v", err)
+	}
+	applied, err := m.Up(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Tags are duplicated: name=a n=2") {
+		t.Fatalf("expected the check to fail listing the duplicates, got %v", err)
+	}
+	if len(applied) != 1 || db.Migrator().HasIndex("tags", "idx_tags_name") || db.Migrator().HasTable("after") {
+		t.Fatalf("expected nothing applied after the failed check, got %+v", applied)
+	}
+
+	// once the data is fixed, the check passes
+	db.Exec("DELETE FROM tags WHERE rowid = (SELECT MAX(rowid) FROM tags WHERE name = 'a')")
+	if applied, err := m.Up(context.Background()); err != nil || len(applied) != 2 {
+		t.Fatalf("expected migrations 2 and 3 applied, got %+v, %v", applied, err)
+	}
+}
+
+func TestReadStatusIsReadOnly(t *testing.T) {
+	db := openDB(t)
+	ctx := context.Background()
+
+	statuses, err := ReadStatus(ctx, db, testFS)
+	if err != nil || len(statuses) != 2 || statuses[0].Applied || statuses[1].Applied {
+		t.Fatalf("expected every migration pending on a fresh database, got %+v, %v", statuses, err)
+	}
+	if db.Migrator().HasTable("schema_migrations") {
+		t.Fatalf("ReadStatus must not create the tracking table")
+	}
+
+	m, err := New(db, testFS)
+	if err != nil {
+		t.Fatalf("new failed: %v", err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	statuses, err = ReadStatus(ctx, db, testFS)
+	if err != nil || !statuses[0].Applied || !statuses[1].Applied {
+		t.Fatalf("expected every migration applied, got %+v, %v", statuses, err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "0007_existing.up.sql"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	up, down, err := Create(dir, "Add Widgets Table")
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if filepath.Base(up) != "0008_add_widgets_table.up.sql" || filepath.Base(down) != "0008_add_widgets_table.down.sql" {
+		t.Fatalf("unexpected file names: %s, %s", up, down)
+	}
+	if _, _, err := Create(dir, "  "); err == nil {
+		t.Fatalf("expected error for empty name")
+	}
+}
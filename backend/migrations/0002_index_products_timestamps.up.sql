@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_products_created_at ON products (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_products_updated_at ON products (updated_at, id);
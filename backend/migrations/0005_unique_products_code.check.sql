@@ -0,0 +1,3 @@
+-- Live products share these codes; rename or delete the duplicates and apply
+-- the migration again
+SELECT code, COUNT(*) AS products FROM products WHERE deleted_at IS NULL GROUP BY code HAVING COUNT(*) > 1 ORDER BY code;
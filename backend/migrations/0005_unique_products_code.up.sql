@@ -0,0 +1,3 @@
+-- Codes must be unique among live products; soft-deleted rows keep theirs
+-- without blocking reuse.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_code_active ON products (code) WHERE deleted_at IS NULL;
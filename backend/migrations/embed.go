@@ -0,0 +1,14 @@
+// Package migrations holds the versioned SQL migrations for the backend
+// schema. Files are named `<version>_<name>.<up|down|check>[.<dialect>].sql`;
+// a dialect-specific file (e.g. `.up.postgres.sql`) takes precedence over
+// the generic one for that database. An optional check script is a query
+// run before the up script: if it returns rows, the migration fails with
+// the script's leading comment and those rows instead of being applied.
+package migrations
+
+import "embed"
+
+// FS contains every migration script in this directory.
+//
+//go:embed *.sql
+var FS embed.FS
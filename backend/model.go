@@ -6,4 +6,15 @@ type Product struct {
 	gorm.Model
 	Code  string
 	Price uint
+	// Version increases on every update and backs the ETag used for
+	// optimistic concurrency control.
+	Version uint `gorm:"not null;default:1"`
+}
+
+// BeforeCreate starts new products at version 1.
+func (p *Product) BeforeCreate(tx *gorm.DB) error {
+	if p.Version == 0 {
+		p.Version = 1
+	}
+	return nil
 }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// Media types the product read endpoints can produce, besides CSV and
+// NDJSON (see import.go).
+const (
+	mediaTypeJSON    = "application/json"
+	mediaTypeMsgpack = "application/msgpack"
+	mediaTypeXML     = "application/xml"
+)
+
+// productMediaTypes lists the negotiable representations of products in
+// order of preference; the JSON envelope comes first so it stays the
+// default for `*/*` and requests without Accept.
+var productMediaTypes = []string{mediaTypeJSON, mediaTypeNDJSON, mediaTypeCSV, mediaTypeMsgpack, mediaTypeXML}
+
+// negotiatedKey is the gin context key holding the negotiated media type.
+const negotiatedKey = "negotiatedMediaType"
+
+// acceptRange is one media range of an Accept header.
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses an Accept header, skipping malformed ranges.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok || typ == "" || subtype == "" {
+			continue
+		}
+		r := acceptRange{typ: typ, subtype: subtype, q: 1}
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q >= 0 && q <= 1 {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// negotiateMediaType picks the offered media type the Accept header
+// prefers most. Each offer takes the quality of its most specific
+// matching range (RFC 9110 §12.5.1); ties go to the earlier offer. An
+// empty header accepts the first offer.
+func negotiateMediaType(header string, offered []string) (string, bool) {
+	if strings.TrimSpace(header) == "" {
+		return offered[0], true
+	}
+	ranges := parseAccept(header)
+
+	best, bestQ := "", 0.0
+	for _, offer := range offered {
+		typ, subtype, _ := strings.Cut(offer, "/")
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.typ == typ && r.subtype == subtype:
+				s = 2
+			case r.typ == typ && r.subtype == "*":
+				s = 1
+			case r.typ == "*" && r.subtype == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, best != ""
+}
+
+// negotiateProducts resolves the request's Accept header against
+// productMediaTypes for the handlers after it, responding 406 Not
+// Acceptable when none is acceptable.
+func negotiateProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept")
+		mediaType, ok := negotiateMediaType(c.GetHeader("Accept"), productMediaTypes)
+		if !ok {
+			respondErrorCode(c, CodeNotAcceptable, map[string]interface{}{
+				"accept":    c.GetHeader("Accept"),
+				"supported": productMediaTypes,
+			})
+			c.Abort()
+			return
+		}
+		c.Set(negotiatedKey, mediaType)
+		c.Next()
+	}
+}
+
+// negotiated returns the media type chosen by negotiateProducts, or the
+// JSON envelope for routes without negotiation.
+func negotiated(c *gin.Context) string {
+	if mediaType := c.GetString(negotiatedKey); mediaType != "" {
+		return mediaType
+	}
+	return mediaTypeJSON
+}
+
+// respondProducts sends products in the negotiated representation. The
+// JSON envelope carries meta in its body; the other formats have no room
+// for it, so it is sent as X-* headers plus a Link header.
+func respondProducts(c *gin.Context, status int, products []Product, meta map[string]interface{}) {
+	mediaType := negotiated(c)
+	if mediaType == mediaTypeJSON {
+		respondSuccess(c, status, products, meta)
+		return
+	}
+	metaHeaders(c, meta)
+	renderProducts(c, status, mediaType, products, false)
+}
+
+// renderProducts writes products as NDJSON, CSV, MessagePack or XML. A
+// single product is sent as a bare record in the latter two, and as a
+// one-row list in the line-based formats.
+func renderProducts(c *gin.Context, status int, mediaType string, products []Product, single bool) {
+	switch mediaType {
+	case mediaTypeNDJSON, mediaTypeCSV:
+		format := exportFormats["ndjson"]
+		if mediaType == mediaTypeCSV {
+			format = exportFormats["csv"]
+		}
+		exporter, err := format.newExporter(c.Writer)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		c.Header("Content-Type", format.contentType)
+		c.Status(status)
+		n := 0
+		for _, p := range products {
+			if err = exporter.Write(p); err != nil {
+				break
+			}
+			n++
+		}
+		if err == nil {
+			err = exporter.Close()
+		}
+		if err != nil {
+			abortProductStream(c, "products: response aborted", n, err)
+		}
+		return
+	}
+
+	records := make([]productRecord, len(products))
+	for i, p := range products {
+		records[i] = newProductRecord(p)
+	}
+	switch mediaType {
+	case mediaTypeMsgpack:
+		if single {
+			c.Render(status, render.MsgPack{Data: records[0]})
+			return
+		}
+		c.Render(status, render.MsgPack{Data: records})
+	case mediaTypeXML:
+		if single {
+			c.XML(status, records[0])
+			return
+		}
+		c.XML(status, struct {
+			XMLName  xml.Name        `xml:"products"`
+			Products []productRecord `xml:"product"`
+		}{Products: records})
+	}
+}
+
+// metaHeaders sends list meta as response headers: `total` as
+// X-Total-Count, other keys as X-<Key> (e.g. per_page as X-Per-Page), and
+// pagination links as an RFC 8288 Link header.
+func metaHeaders(c *gin.Context, meta map[string]interface{}) {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := meta[k]
+		if v == nil {
+			continue
+		}
+		name := "X-" + http.CanonicalHeaderKey(strings.ReplaceAll(k, "_", "-"))
+		if k == "total" {
+			name = "X-Total-Count"
+		}
+		c.Header(name, fmt.Sprint(v))
+	}
+
+	var links []string
+	link := func(rel string, set map[string]string) {
+		u := *c.Request.URL
+		q := u.Query()
+		for k, v := range set {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel))
+	}
+
+	if page, ok := meta["page"].(int); ok {
+		totalPages, _ := meta["total_pages"].(int)
+		link("first", map[string]string{"page": "1"})
+		if page > 1 {
+			link("prev", map[string]string{"page": strconv.Itoa(page - 1)})
+		}
+		if page < totalPages {
+			link("next", map[string]string{"page": strconv.Itoa(page + 1)})
+		}
+		if totalPages > 0 {
+			link("last", map[string]string{"page": strconv.Itoa(totalPages)})
+		}
+	}
+	if next, ok := meta["next_cursor"].(string); ok {
+		link("next", map[string]string{"cursor": next})
+	}
+	if prev, ok := meta["prev_cursor"].(string); ok {
+		link("prev", map[string]string{"cursor": prev})
+	}
+
+	if len(links) > 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestNegotiateMediaType(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"", mediaTypeJSON, true},
+		{"*/*", mediaTypeJSON, true},
+		{"text/csv", mediaTypeCSV, true},
+		{"text/*", mediaTypeCSV, true},
+		{"application/xml;q=0.5, application/msgpack", mediaTypeMsgpack, true},
+		{"application/json;q=0.1, */*;q=0.5", mediaTypeNDJSON, true},
+		{"text/csv;q=0, */*", mediaTypeJSON, true},
+		{"APPLICATION/X-NDJSON", mediaTypeNDJSON, true},
+		{"text/html", "", false},
+		{"application/json;q=0", "", false},
+		{"garbage", "", false},
+	}
+	for _, tc := range cases {
+		got, ok := negotiateMediaType(tc.accept, productMediaTypes)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("negotiateMediaType(%q) = %q, %v; want %q, %v", tc.accept, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cursor is a decoded keyset position: the values of the keyset sort
+// fields (see ProductQuery.keysetSort) of the row a page starts after, or
+// before when Before is set.
+type Cursor struct {
+	Values []interface{}
+	Before bool
+}
+
+// cursorPayload is the JSON form of a Cursor. Values are kept as strings
+// and the sort is recorded so a cursor can't be replayed with another one.
+type cursorPayload struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	Before bool     `json:"b,omitempty"`
+}
+
+// sortKey renders the query's sort as it appears in the `sort` parameter.
+func (q ProductQuery) sortKey() string {
+	parts := make([]string, 0, len(q.Sort))
+	for _, s := range q.Sort {
+		if s.Desc {
+			parts = append(parts, "-"+s.Field)
+		} else {
+			parts = append(parts, s.Field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// encodeCursor returns the opaque cursor for a page starting after (or
+// before) p under q's sort.
+func encodeCursor(q ProductQuery, p Product, before bool) string {
+	payload := cursorPayload{Sort: q.sortKey(), Before: before}
+	for _, v := range keysetValues(p, q.keysetSort(false)) {
+		switch v := v.(type) {
+		case uint64:
+			payload.Values = append(payload.Values, strconv.FormatUint(v, 10))
+		case string:
+			payload.Values = append(payload.Values, v)
+		case time.Time:
+			payload.Values = append(payload.Values, v.Format(time.RFC3339Nano))
+		}
+	}
+	b, _ := json.Marshal(payload)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor produced by encodeCursor for the same sort.
+func decodeCursor(q ProductQuery, raw string) (*Cursor, *QueryError) {
+	invalid := func(reason string) (*Cursor, *QueryError) {
+		return nil, &QueryError{Param: "cursor", Reason: reason}
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return invalid("malformed cursor")
+	}
+	var payload cursorPayload
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return invalid("malformed cursor")
+	}
+	if payload.Sort != q.sortKey() {
+		return invalid(fmt.Sprintf("cursor was issued for sort %q", payload.Sort))
+	}
+
+	sort := q.keysetSort(false)
+	if len(payload.Values) != len(sort) {
+		return invalid("malformed cursor")
+	}
+	cur := &Cursor{Before: payload.Before}
+	for i, s := range sort {
+		v, err := parseFieldValue(productFields[s.Field], payload.Values[i])
+		if err != nil {
+			return invalid("malformed cursor")
+		}
+		cur.Values = append(cur.Values, v)
+	}
+	return cur, nil
+}
+
+// listProductsByCursor serves GET /products in keyset mode
+// (`?cursor=...&limit=...`). Pages are read by (sort fields, id) so they
+// stay stable while rows are inserted; `include_total=false` skips the
+// COUNT(*) query.
+func listProductsByCursor(c *gin.Context, repo ProductRepository, query ProductQuery, maxPerPage int) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		limit = 20
+	}
+	if limit > maxPerPage {
+		respondErrorCode(c, CodePerPageTooLarge, map[string]interface{}{"requested": limit, "max_per_page": maxPerPage})
+		return
+	}
+
+	includeTotal := true
+	if v := c.Query("include_total"); v != "" {
+		includeTotal, err = strconv.ParseBool(v)
+		if err != nil {
+			respondErrorCode(c, CodeInvalidRequest, map[string]interface{}{"include_total": v})
+			return
+		}
+	}
+
+	var cur *Cursor
+	if raw := c.Query("cursor"); raw != "" {
+		var qerr *QueryError
+		cur, qerr = decodeCursor(query, raw)
+		if qerr != nil {
+			respondErrorCode(c, CodeInvalidCursor, qerr.Details())
+			return
+		}
+	}
+
+	// Fetch one extra row to learn whether another page exists.
+	products, err := repo.ListKeyset(c.Request.Context(), query, cur, limit+1)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	hasMore := len(products) > limit
+	if hasMore {
+		products = products[:limit]
+	}
+	backward := cur != nil && cur.Before
+	if backward {
+		slices.Reverse(products)
+	}
+
+	var next, prev interface{}
+	if len(products) > 0 {
+		first, last := products[0], products[len(products)-1]
+		if hasMore || backward {
+			next = encodeCursor(query, last, false)
+		}
+		if (hasMore && backward) || (cur != nil && !backward) {
+			prev = encodeCursor(query, first, true)
+		}
+	}
+
+	meta := map[string]interface{}{
+		"limit":       limit,
+		"next_cursor": next,
+		"prev_cursor": prev,
+	}
+	if includeTotal {
+		total, err := repo.Count(c.Request.Context(), query)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		meta["total"] = total
+	}
+
+	respondProducts(c, http.StatusOK, products, meta)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Media types accepted by PATCH /product/:id.
+const (
+	mediaTypeMergePatch = "application/merge-patch+json" // RFC 7396
+	mediaTypeJSONPatch  = "application/json-patch+json"  // RFC 6902
+)
+
+// productDocument is the JSON document patches are applied to. Field
+// names follow the request bodies of POST/PUT.
+type productDocument struct {
+	ID        uint      `json:"id"`
+	Code      string    `json:"code"`
+	Price     uint      `json:"price"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   uint      `json:"version"`
+}
+
+// patchFields maps every accepted spelling of a product field (JSON name
+// or Go name, case-insensitive, underscores optional) to its document name,
+// and records whether the field may be changed.
+var patchFields = map[string]struct {
+	name     string
+	writable bool
+}{
+	"id":        {"id", false},
+	"code":      {"code", true},
+	"price":     {"price", true},
+	"createdat": {"created_at", false},
+	"updatedat": {"updated_at", false},
+	"deletedat": {"deleted_at", false},
+	"version":   {"version", false},
+}
+
+// patchError is a patch rejection with the response it maps to. A
+// non-nil cause marks an internal failure, reported without details.
+type patchError struct {
+	code    string
+	details interface{}
+	cause   error
+}
+
+// patchField resolves a field name from a patch, rejecting unknown and
+// read-only fields unless readOnlyOK is set.
+func patchField(raw string, readOnlyOK bool) (string, *patchError) {
+	f, ok := patchFields[strings.ToLower(strings.ReplaceAll(raw, "_", ""))]
+	if !ok {
+		return "", &patchError{code: CodeInvalidRequest, details: map[string]interface{}{"field": raw, "reason": "unknown field"}}
+	}
+	if !f.writable && !readOnlyOK {
+		return "", &patchError{code: CodeReadOnlyField, details: map[string]interface{}{"field": raw}}
+	}
+	return f.name, nil
+}
+
+// patchPointer resolves the field named by a JSON Pointer such as
+// "/price" and returns the pointer rewritten to the document name.
+func patchPointer(pointer string, readOnlyOK bool) (string, *patchError) {
+	if !strings.HasPrefix(pointer, "/") || pointer == "/" {
+		return "", &patchError{code: CodeInvalidRequest, details: map[string]interface{}{"path": pointer, "reason": "path must name a product field"}}
+	}
+	first, rest, _ := strings.Cut(pointer[1:], "/")
+	name, perr := patchField(first, readOnlyOK)
+	if perr != nil {
+		return "", perr
+	}
+	if rest != "" {
+		return "/" + name + "/" + rest, nil
+	}
+	return "/" + name, nil
+}
+
+// applyProductPatch applies a merge patch or JSON patch body to p and
+// returns the writable fields whose values changed.
+func applyProductPatch(p Product, contentType string, body []byte) (ProductChanges, *patchError) {
+	doc, err := json.Marshal(productDocument{ID: p.ID, Code: p.Code, Price: p.Price, CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt, Version: p.Version})
+	if err != nil {
+		return ProductChanges{}, &patchError{code: CodeInternalError, cause: err}
+	}
+	invalid := func(reason string) (ProductChanges, *patchError) {
+		return ProductChanges{}, &patchError{code: CodeInvalidRequest, details: reason}
+	}
+
+	var patched []byte
+	switch contentType {
+	case mediaTypeMergePatch:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+			return invalid("merge patch must be a JSON object")
+		}
+		normalized := make(map[string]json.RawMessage, len(fields))
+		for k, v := range fields {
+			name, perr := patchField(k, false)
+			if perr != nil {
+				return ProductChanges{}, perr
+			}
+			normalized[name] = v
+		}
+		patch, _ := json.Marshal(normalized)
+		if patched, err = jsonpatch.MergePatch(doc, patch); err != nil {
+			return invalid(err.Error())
+		}
+
+	case mediaTypeJSONPatch:
+		var ops []map[string]interface{}
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return invalid("JSON patch must be an array of operations")
+		}
+		for _, op := range ops {
+			kind, _ := op["op"].(string)
+			path, _ := op["path"].(string)
+			// test only reads, so it may reference read-only fields
+			rewritten, perr := patchPointer(path, kind == "test")
+			if perr != nil {
+				return ProductChanges{}, perr
+			}
+			op["path"] = rewritten
+			if from, ok := op["from"].(string); ok {
+				// copy only reads its source; move removes it
+				rewritten, perr := patchPointer(from, kind == "copy")
+				if perr != nil {
+					return ProductChanges{}, perr
+				}
+				op["from"] = rewritten
+			}
+		}
+		raw, _ := json.Marshal(ops)
+		patch, err := jsonpatch.DecodePatch(raw)
+		if err != nil {
+			return invalid(err.Error())
+		}
+		if patched, err = patch.Apply(doc); err != nil {
+			return ProductChanges{}, &patchError{code: CodePatchFailed, details: err.Error()}
+		}
+
+	default:
+		return ProductChanges{}, &patchError{code: CodeUnsupportedMedia, details: map[string]interface{}{
+			"content_type": contentType,
+			"supported":    []string{mediaTypeMergePatch, mediaTypeJSONPatch},
+		}}
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return invalid("patched document is not a JSON object")
+	}
+	var original map[string]json.RawMessage
+	_ = json.Unmarshal(doc, &original)
+
+	var changes ProductChanges
+	for name, before := range original {
+		after, ok := result[name]
+		if !ok {
+			return invalid(fmt.Sprintf("%s cannot be removed", name))
+		}
+		if sameJSON(before, after) {
+			continue
+		}
+		switch name {
+		case "code":
+			var code string
+			if err := json.Unmarshal(after, &code); err != nil {
+				return invalid("code must be a string")
+			}
+			changes.Code = &code
+		case "price":
+			var price uint
+			if err := json.Unmarshal(after, &price); err != nil {
+				return invalid("price must be a non-negative integer")
+			}
+			changes.Price = &price
+		default:
+			return ProductChanges{}, &patchError{code: CodeReadOnlyField, details: map[string]interface{}{"field": name}}
+		}
+	}
+	for name := range result {
+		if _, ok := original[name]; !ok {
+			return invalid(fmt.Sprintf("unknown field %s", name))
+		}
+	}
+	return changes, nil
+}
+
+// sameJSON reports whether two JSON values are equal regardless of
+// formatting.
+func sameJSON(a, b json.RawMessage) bool {
+	var av, bv interface{}
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
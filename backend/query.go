@@ -0,0 +1,353 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fieldKind is the value type of a filterable/sortable product field.
+type fieldKind int
+
+const (
+	kindUint fieldKind = iota
+	kindString
+	kindTime
+)
+
+// productFields whitelists the query fields of Product, keyed by their
+// JSON/column name. Only these names ever reach generated SQL.
+var productFields = map[string]fieldKind{
+	"id":         kindUint,
+	"code":       kindString,
+	"price":      kindUint,
+	"created_at": kindTime,
+	"updated_at": kindTime,
+}
+
+// FilterOp is a comparison operator usable as `field[op]=value`.
+type FilterOp string
+
+const (
+	OpEq     FilterOp = "eq"
+	OpNe     FilterOp = "ne"
+	OpGt     FilterOp = "gt"
+	OpGte    FilterOp = "gte"
+	OpLt     FilterOp = "lt"
+	OpLte    FilterOp = "lte"
+	OpPrefix FilterOp = "prefix"
+)
+
+// sqlOps maps the comparison operators to SQL.
+var sqlOps = map[FilterOp]string{
+	OpEq:  "=",
+	OpNe:  "<>",
+	OpGt:  ">",
+	OpGte: ">=",
+	OpLt:  "<",
+	OpLte: "<=",
+}
+
+// Filter restricts a product field with an operator. Value holds a uint64,
+// string or time.Time depending on the field kind.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value interface{}
+}
+
+// SortField orders by a product field, descending when Desc is set.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ProductQuery is the parsed filter, sort and search part of a product
+// listing request. The zero value matches every product in id order.
+type ProductQuery struct {
+	Filters []Filter
+	Sort    []SortField
+	Search  string
+}
+
+// QueryError reports an invalid query parameter.
+type QueryError struct {
+	Param  string
+	Reason string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid query parameter %q: %s", e.Param, e.Reason)
+}
+
+// Details returns the error details sent to clients.
+func (e *QueryError) Details() map[string]interface{} {
+	return map[string]interface{}{"parameter": e.Param, "reason": e.Reason}
+}
+
+// filterKeyRe matches `field[op]` query keys.
+var filterKeyRe = regexp.MustCompile(`^([a-z_]+)\[([a-z]+)\]$`)
+
+// parseProductQuery reads `sort`, `q`, `field=value` and `field[op]=value`
+// parameters from values. Other plain parameters (page, per_page, ...) are
+// left to the caller; any `name[op]` key must name a whitelisted field.
+func parseProductQuery(values url.Values) (ProductQuery, *QueryError) {
+	var q ProductQuery
+	q.Search = strings.TrimSpace(values.Get("q"))
+
+	if raw := values.Get("sort"); raw != "" {
+		seen := map[string]bool{}
+		for _, part := range strings.Split(raw, ",") {
+			part = strings.TrimSpace(part)
+			desc := strings.HasPrefix(part, "-")
+			field := strings.TrimPrefix(part, "-")
+			if _, ok := productFields[field]; !ok {
+				return q, &QueryError{Param: "sort", Reason: fmt.Sprintf("cannot sort by %q", field)}
+			}
+			if seen[field] {
+				return q, &QueryError{Param: "sort", Reason: fmt.Sprintf("%q listed more than once", field)}
+			}
+			seen[field] = true
+			q.Sort = append(q.Sort, SortField{Field: field, Desc: desc})
+		}
+	}
+
+	for key, vals := range values {
+		field, op := key, OpEq
+		if m := filterKeyRe.FindStringSubmatch(key); m != nil {
+			field, op = m[1], FilterOp(m[2])
+			if _, ok := productFields[field]; !ok {
+				return q, &QueryError{Param: key, Reason: fmt.Sprintf("cannot filter by %q", field)}
+			}
+		} else if strings.Contains(key, "[") {
+			return q, &QueryError{Param: key, Reason: "malformed filter"}
+		} else if _, ok := productFields[field]; !ok {
+			continue
+		}
+
+		kind := productFields[field]
+		if _, ok := sqlOps[op]; !ok && !(op == OpPrefix && kind == kindString) {
+			return q, &QueryError{Param: key, Reason: fmt.Sprintf("unsupported operator %q for %q", op, field)}
+		}
+		for _, raw := range vals {
+			value, err := parseFieldValue(kind, raw)
+			if err != nil {
+				return q, &QueryError{Param: key, Reason: err.Error()}
+			}
+			q.Filters = append(q.Filters, Filter{Field: field, Op: op, Value: value})
+		}
+	}
+
+	return q, nil
+}
+
+// parseFieldValue converts a raw query value to the Go type of kind.
+// Times accept RFC 3339 or a plain YYYY-MM-DD date (midnight UTC).
+func parseFieldValue(kind fieldKind, raw string) (interface{}, error) {
+	switch kind {
+	case kindUint:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a non-negative integer", raw)
+		}
+		return n, nil
+	case kindTime:
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse("2006-01-02", raw); err == nil {
+			return t, nil
+		}
+		return nil, fmt.Errorf("%q is not an RFC 3339 timestamp or YYYY-MM-DD date", raw)
+	}
+	return raw, nil
+}
+
+// likeEscaper escapes LIKE wildcards so user input matches literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// apply adds the query's conditions to db. Column names come
+// from productFields only. Prefix and search matching is case-insensitive.
+func (q ProductQuery) apply(db *gorm.DB) *gorm.DB {
+	for _, f := range q.Filters {
+		if f.Op == OpPrefix {
+			db = db.Where(`LOWER(`+f.Field+`) LIKE ? ESCAPE '\'`, likeEscaper.Replace(strings.ToLower(f.Value.(string)))+"%")
+			continue
+		}
+		db = db.Where(f.Field+" "+sqlOps[f.Op]+" ?", f.Value)
+	}
+	if q.Search != "" {
+		db = db.Where(`LOWER(code) LIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(strings.ToLower(q.Search))+"%")
+	}
+	return db
+}
+
+// order adds the query's ordering to db, always ending with id so the
+// order is deterministic.
+func (q ProductQuery) order(db *gorm.DB) *gorm.DB {
+	hasID := false
+	for _, s := range q.Sort {
+		dir := " ASC"
+		if s.Desc {
+			dir = " DESC"
+		}
+		db = db.Order(s.Field + dir)
+		hasID = hasID || s.Field == "id"
+	}
+	if !hasID {
+		db = db.Order("id ASC")
+	}
+	return db
+}
+
+// productValue returns the value of a whitelisted field of p, typed to
+// match the parsed filter values.
+func productValue(p Product, field string) interface{} {
+	switch field {
+	case "id":
+		return uint64(p.ID)
+	case "code":
+		return p.Code
+	case "price":
+		return uint64(p.Price)
+	case "created_at":
+		return p.CreatedAt
+	case "updated_at":
+		return p.UpdatedAt
+	}
+	return nil
+}
+
+// compareValues orders two values of the same field kind.
+func compareValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case uint64:
+		return cmp.Compare(av, b.(uint64))
+	case string:
+		return strings.Compare(av, b.(string))
+	case time.Time:
+		return av.Compare(b.(time.Time))
+	}
+	return 0
+}
+
+// Match reports whether p satisfies every filter and the search term,
+// mirroring apply for in-memory stores.
+func (q ProductQuery) Match(p Product) bool {
+	for _, f := range q.Filters {
+		v := productValue(p, f.Field)
+		if f.Op == OpPrefix {
+			if !strings.HasPrefix(strings.ToLower(v.(string)), strings.ToLower(f.Value.(string))) {
+				return false
+			}
+			continue
+		}
+		c := compareValues(v, f.Value)
+		var ok bool
+		switch f.Op {
+		case OpEq:
+			ok = c == 0
+		case OpNe:
+			ok = c != 0
+		case OpGt:
+			ok = c > 0
+		case OpGte:
+			ok = c >= 0
+		case OpLt:
+			ok = c < 0
+		case OpLte:
+			ok = c <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	if q.Search != "" && !strings.Contains(strings.ToLower(p.Code), strings.ToLower(q.Search)) {
+		return false
+	}
+	return true
+}
+
+// Compare orders a and b by the query's sort fields, then by id,
+// mirroring order for in-memory stores.
+func (q ProductQuery) Compare(a, b Product) int {
+	for _, s := range q.Sort {
+		c := compareValues(productValue(a, s.Field), productValue(b, s.Field))
+		if s.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// keysetSort returns the query's sort fields with id appended as the
+// final tiebreaker (unless already present), flipped when reverse is set
+// so a page can be read backwards from a cursor.
+func (q ProductQuery) keysetSort(reverse bool) []SortField {
+	sort := make([]SortField, 0, len(q.Sort)+1)
+	hasID := false
+	for _, s := range q.Sort {
+		sort = append(sort, SortField{Field: s.Field, Desc: s.Desc != reverse})
+		hasID = hasID || s.Field == "id"
+	}
+	if !hasID {
+		sort = append(sort, SortField{Field: "id", Desc: reverse})
+	}
+	return sort
+}
+
+// keysetWhere builds the condition selecting rows strictly after values in
+// the given sort order, expanded as
+// (a > ?) OR (a = ? AND b > ?) OR (a = ? AND b = ? AND id > ?).
+func keysetWhere(sort []SortField, values []interface{}) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for i, s := range sort {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, sort[j].Field+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if s.Desc {
+			op = " < ?"
+		}
+		ands = append(ands, s.Field+op)
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return strings.Join(ors, " OR "), args
+}
+
+// keysetValues returns p's values for the given sort fields.
+func keysetValues(p Product, sort []SortField) []interface{} {
+	values := make([]interface{}, len(sort))
+	for i, s := range sort {
+		values[i] = productValue(p, s.Field)
+	}
+	return values
+}
+
+// compareKeyset orders p against the cursor values in the given sort
+// order, mirroring keysetWhere for in-memory stores.
+func compareKeyset(p Product, sort []SortField, values []interface{}) int {
+	for i, s := range sort {
+		c := compareValues(productValue(p, s.Field), values[i])
+		if s.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseProductQuery(t *testing.T) {
+	values, _ := url.ParseQuery("sort=-price,code&price[gte]=100&price[lt]=500&code[prefix]=ABC&created_at[gte]=2024-01-01&q=%20widget%20&page=2&per_page=10")
+	q, qerr := parseProductQuery(values)
+	if qerr != nil {
+		t.Fatalf("unexpected error: %v", qerr)
+	}
+
+	if len(q.Sort) != 2 || q.Sort[0] != (SortField{Field: "price", Desc: true}) || q.Sort[1] != (SortField{Field: "code"}) {
+		t.Fatalf("unexpected sort: %+v", q.Sort)
+	}
+	if q.Search != "widget" {
+		t.Fatalf("expected trimmed search term, got %q", q.Search)
+	}
+	if len(q.Filters) != 4 {
+		t.Fatalf("expected 4 filters, got %+v", q.Filters)
+	}
+	for _, f := range q.Filters {
+		if f.Field == "created_at" && !f.Value.(time.Time).Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("unexpected date value: %v", f.Value)
+		}
+	}
+}
+
+func TestParseProductQueryRejectsInvalid(t *testing.T) {
+	cases := map[string]string{
+		"sort=password":          "sort",
+		"sort=price,-price":      "sort",
+		"deleted_at[lt]=1":       "deleted_at[lt]",
+		"price[like]=1":          "price[like]",
+		"price[prefix]=1":        "price[prefix]",
+		"price[gte]=cheap":       "price[gte]",
+		"created_at[gt]=01/2024": "created_at[gt]",
+		"code[=x":                "code[",
+	}
+	for raw, param := range cases {
+		values, _ := url.ParseQuery(raw)
+		_, qerr := parseProductQuery(values)
+		if qerr == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+		if qerr.Param != param {
+			t.Fatalf("expected %q to name parameter %q, got %q", raw, param, qerr.Param)
+		}
+	}
+}
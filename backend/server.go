@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownState records whether the server has begun shutting down, so
+// GET /readyz can fail while in-flight requests drain.
+type shutdownState struct {
+	draining atomic.Bool
+}
+
+// Draining reports whether shutdown has begun.
+func (s *shutdownState) Draining() bool {
+	return s.draining.Load()
+}
+
+// serve runs srv on ln until ctx is done (main cancels it on SIGINT or
+// SIGTERM). Shutdown then marks state as draining, waits delay for load
+// balancers to see readiness fail, stops accepting connections and waits
+// up to timeout for in-flight requests to finish. It returns
+// context.DeadlineExceeded when requests were still running at the
+// deadline, or the error that stopped the server early.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, state *shutdownState, delay, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	state.draining.Store(true)
+	slog.Info("shutdown: draining in-flight requests", slog.Duration("delay", delay), slog.Duration("timeout", timeout))
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		_ = srv.Close()
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
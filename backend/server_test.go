@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	url := "http://" + ln.Addr().String()
+	state := &shutdownState{}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, &http.Server{Handler: mux}, ln, state, 0, 5*time.Second) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		inFlight <- result{string(body), err}
+	}()
+	<-started
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for !state.Draining() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the server to start draining")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+
+	if res := <-inFlight; res.err != nil || res.body != "done" {
+		t.Fatalf("expected the in-flight request to complete, got %q, %v", res.body, res.err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("expected a clean shutdown, got %v", err)
+	}
+	if _, err := http.Get(url + "/slow"); err == nil {
+		t.Fatal("expected new connections to be refused after shutdown")
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stuck", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, &http.Server{Handler: mux}, ln, &shutdownState{}, 0, 50*time.Millisecond) }()
+	go func() { _, _ = http.Get("http://" + ln.Addr().String() + "/stuck") }()
+	<-started
+
+	cancel()
+	if err := <-served; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the drain to time out, got %v", err)
+	}
+}
+
+func TestReadyzFailsWhileDraining(t *testing.T) {
+	state := &shutdownState{}
+	r := newRouter(NewMemoryProductRepository(), WithShutdownState(state))
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w
+	}
+
+	if w := get(); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 before shutdown, got %d: %s", w.Code, w.Body.String())
+	}
+	state.draining.Store(true)
+	w := get()
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while draining, got %d", w.Code)
+	}
+	if code := decodeEnvelope(t, w)["error"].(map[string]interface{})["code"]; code != CodeShuttingDown {
+		t.Fatalf("expected %s, got %v", CodeShuttingDown, code)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// tracerName is the instrumentation scope of the application's spans.
+const tracerName = "may"
+
+// tracePropagator reads and writes the W3C traceparent, tracestate and
+// baggage headers.
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// newTracerProvider returns a tracer provider sending spans to exporter:
+// "otlp" (OTLP over HTTP, configured with the standard
+// OTEL_EXPORTER_OTLP_* variables), "stdout", or "none" (or empty), which
+// still creates trace IDs for propagation and logs but exports nothing.
+// Unknown exporters are logged and treated as "none". Sampling follows
+// OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG (parentbased_always_on
+// by default) and the service name OTEL_SERVICE_NAME ("may" by default).
+func newTracerProvider(ctx context.Context, exporter string) (*sdktrace.TracerProvider, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(tracerName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	switch exporter {
+	case "otlp":
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case "stdout":
+		exp, err := stdouttrace.New()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case "", "none":
+	default:
+		slog.Warn("tracing: unknown exporter, spans are not exported", slog.String("exporter", exporter))
+	}
+	return sdktrace.NewTracerProvider(opts...), nil
+}
+
+// tracingMiddleware starts a server span for every request, continuing
+// the trace of an incoming traceparent header, and stores it in the
+// request context so DAL and SQL spans become its children. Spans are
+// named after the route template (e.g. `GET /product/:id`), or just the
+// method for requests no route handles, and fail on 5xx responses.
+func tracingMiddleware(tracer trace.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracePropagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		name := c.Request.Method
+		attrs := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(c.Request.Method),
+			semconv.URLPath(c.Request.URL.Path),
+			semconv.ClientAddress(c.ClientIP()),
+			semconv.UserAgentOriginal(c.Request.UserAgent()),
+		}
+		if route != "" {
+			name += " " + route
+			attrs = append(attrs, semconv.HTTPRoute(route))
+		}
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(otelcodes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// endSpan ends span, recording err unless it is an outcome the API
+// reports to the client (missing products and conflicts).
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) &&
+		!errors.Is(err, ErrVersionConflict) && !errors.Is(err, ErrCodeConflict) {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedRepository wraps a ProductRepository with a span per call, named
+// after the method (e.g. `ProductRepository.GetByID`).
+type tracedRepository struct {
+	next   ProductRepository
+	tracer trace.Tracer
+	// tx is the span of the transaction this repository runs in, so calls
+	// made inside it become its children.
+	tx trace.Span
+}
+
+// newTracedRepository returns repo traced with tracer.
+func newTracedRepository(repo ProductRepository, tracer trace.Tracer) ProductRepository {
+	return tracedRepository{next: repo, tracer: tracer}
+}
+
+func (r tracedRepository) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if r.tx != nil {
+		ctx = trace.ContextWithSpan(ctx, r.tx)
+	}
+	return r.tracer.Start(ctx, "ProductRepository."+method, trace.WithAttributes(attrs...))
+}
+
+func (r tracedRepository) Latest(ctx context.Context, by LatestBy, n int) (_ []Product, err error) {
+	ctx, span := r.start(ctx, "Latest", attribute.Int("limit", n))
+	defer func() { endSpan(span, err) }()
+	return r.next.Latest(ctx, by, n)
+}
+
+func (r tracedRepository) List(ctx context.Context, q ProductQuery, page int, perPage int) (_ []Product, _ int64, err error) {
+	ctx, span := r.start(ctx, "List", attribute.Int("page", page), attribute.Int("per_page", perPage))
+	defer func() { endSpan(span, err) }()
+	return r.next.List(ctx, q, page, perPage)
+}
+
+func (r tracedRepository) ListKeyset(ctx context.Context, q ProductQuery, cur *Cursor, limit int) (_ []Product, err error) {
+	ctx, span := r.start(ctx, "ListKeyset", attribute.Int("limit", limit))
+	defer func() { endSpan(span, err) }()
+	return r.next.ListKeyset(ctx, q, cur, limit)
+}
+
+func (r tracedRepository) Count(ctx context.Context, q ProductQuery) (_ int64, err error) {
+	ctx, span := r.start(ctx, "Count")
+	defer func() { endSpan(span, err) }()
+	return r.next.Count(ctx, q)
+}
+
+func (r tracedRepository) Each(ctx context.Context, q ProductQuery, fn func(Product) error) (err error) {
+	ctx, span := r.start(ctx, "Each")
+	defer func() { endSpan(span, err) }()
+	return r.next.Each(ctx, q, fn)
+}
+
+func (r tracedRepository) GetByID(ctx context.Context, id uint) (_ Product, err error) {
+	ctx, span := r.start(ctx, "GetByID", attribute.Int64("product.id", int64(id)))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetByID(ctx, id)
+}
+
+func (r tracedRepository) GetByCodes(ctx context.Context, codes []string) (_ []Product, err error) {
+	ctx, span := r.start(ctx, "GetByCodes", attribute.Int("codes", len(codes)))
+	defer func() { endSpan(span, err) }()
+	return r.next.GetByCodes(ctx, codes)
+}
+
+func (r tracedRepository) Create(ctx context.Context, code string, price uint) (_ Product, err error) {
+	ctx, span := r.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return r.next.Create(ctx, code, price)
+}
+
+func (r tracedRepository) Update(ctx context.Context, id uint, changes ProductChanges, ifVersion uint) (_ Product, err error) {
+	ctx, span := r.start(ctx, "Update", attribute.Int64("product.id", int64(id)))
+	defer func() { endSpan(span, err) }()
+	return r.next.Update(ctx, id, changes, ifVersion)
+}
+
+func (r tracedRepository) Delete(ctx context.Context, id uint, ifVersion uint) (err error) {
+	ctx, span := r.start(ctx, "Delete", attribute.Int64("product.id", int64(id)))
+	defer func() { endSpan(span, err) }()
+	return r.next.Delete(ctx, id, ifVersion)
+}
+
+func (r tracedRepository) UpsertByCode(ctx context.Context, code string, price uint) (_ Product, _ bool, err error) {
+	ctx, span := r.start(ctx, "UpsertByCode")
+	defer func() { endSpan(span, err) }()
+	return r.next.UpsertByCode(ctx, code, price)
+}
+
+func (r tracedRepository) ListDeleted(ctx context.Context, page int, perPage int) (_ []Product, _ int64, err error) {
+	ctx, span := r.start(ctx, "ListDeleted", attribute.Int("page", page), attribute.Int("per_page", perPage))
+	defer func() { endSpan(span, err) }()
+	return r.next.ListDeleted(ctx, page, perPage)
+}
+
+func (r tracedRepository) Restore(ctx context.Context, id uint) (_ Product, err error) {
+	ctx, span := r.start(ctx, "Restore", attribute.Int64("product.id", int64(id)))
+	defer func() { endSpan(span, err) }()
+	return r.next.Restore(ctx, id)
+}
+
+func (r tracedRepository) Purge(ctx context.Context, id uint) (err error) {
+	ctx, span := r.start(ctx, "Purge", attribute.Int64("product.id", int64(id)))
+	defer func() { endSpan(span, err) }()
+	return r.next.Purge(ctx, id)
+}
+
+func (r tracedRepository) PurgeDeleted(ctx context.Context, cutoff time.Time) (_ int64, err error) {
+	ctx, span := r.start(ctx, "PurgeDeleted")
+	defer func() { endSpan(span, err) }()
+	return r.next.PurgeDeleted(ctx, cutoff)
+}
+
+func (r tracedRepository) Transaction(ctx context.Context, fn func(tx ProductRepository) error) (err error) {
+	ctx, span := r.start(ctx, "Transaction")
+	defer func() { endSpan(span, err) }()
+	return r.next.Transaction(ctx, func(tx ProductRepository) error {
+		return fn(tracedRepository{next: tx, tracer: r.tracer, tx: span})
+	})
+}
+
+// tracingSpanKey is the gorm instance key holding a statement's span.
+const tracingSpanKey = "tracing:span"
+
+// InstrumentDBTracing adds a client span per GORM statement, named after
+// its operation (e.g. `gorm.query`) and carrying the SQL with its
+// placeholders, never the values. Only statements run with a traced
+// context get a span, so migrations, background jobs and metrics scrapes
+// don't start traces of their own.
+func InstrumentDBTracing(db *gorm.DB, tp trace.TracerProvider) error {
+	tracer := tp.Tracer(tracerName)
+	system := db.Dialector.Name()
+	before := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx := tx.Statement.Context
+			if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+				return
+			}
+			_, span := tracer.Start(ctx, "gorm."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(semconv.DBSystemNameKey.String(system)),
+			)
+			tx.InstanceSet(tracingSpanKey, span)
+		}
+	}
+	after := func(string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			v, ok := tx.InstanceGet(tracingSpanKey)
+			if !ok {
+				return
+			}
+			span := v.(trace.Span)
+			span.SetAttributes(
+				semconv.DBQueryText(tx.Statement.SQL.String()),
+				semconv.DBCollectionName(tx.Statement.Table),
+				attribute.Int64("db.response.affected_rows", tx.Statement.RowsAffected),
+			)
+			endSpan(span, tx.Error)
+		}
+	}
+	return registerStatementCallbacks(db, "tracing", before, after)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isAdmin reports whether the request carries `Authorization: Bearer
+// <token>` for the configured admin token. With no token configured
+// nobody is an admin.
+func isAdmin(c *gin.Context, token string) bool {
+	if token == "" {
+		return false
+	}
+	got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// purgeDeletedProducts permanently removes products that have been in the
+// trash longer than retention, checking every interval until ctx is done.
+func purgeDeletedProducts(ctx context.Context, repo ProductRepository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n, err := repo.PurgeDeleted(ctx, now.Add(-retention)); err != nil {
+				slog.ErrorContext(ctx, "trash: purge failed", slog.String("error", err.Error()))
+			} else if n > 0 {
+				slog.InfoContext(ctx, "trash: purged deleted products", slog.Int64("count", n), slog.Time("deleted_before", now.Add(-retention)))
+			}
+		}
+	}
+}
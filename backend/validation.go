@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// productCodePattern is the charset of product codes: letters, digits,
+// '.', '_' and '-', starting with a letter or digit. cmd/genfrontend
+// copies it into the frontend rules, so keep it a plain backquoted string.
+const productCodePattern = `^[A-Za-z0-9][A-Za-z0-9._-]*$`
+
+var productCodeRe = regexp.MustCompile(productCodePattern)
+
+// productPayload is the product body of POST /product and PUT
+// /product/:id. Its binding tags are the validation rules of every
+// product write (PATCH, bulk and import included) and are emitted for
+// the React form by cmd/genfrontend.
+type productPayload struct {
+	Code  string `json:"code" binding:"required,max=64,productcode"`
+	Price uint   `json:"price" binding:"required,max=1000000000"`
+}
+
+// FieldError is a single failed validation rule, reported with the JSON
+// name of the field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func init() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	// report fields by their JSON names
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return f.Name
+		}
+		return name
+	})
+	_ = v.RegisterValidation("productcode", func(fl validator.FieldLevel) bool {
+		return productCodeRe.MatchString(fl.Field().String())
+	})
+}
+
+// fieldMessage renders the message for a failed rule. Keep in sync with
+// the messages cmd/genfrontend emits.
+func fieldMessage(fe validator.FieldError) string {
+	isString := fe.Kind() == reflect.String
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", fe.Field())
+	case "min":
+		if isString {
+			return fmt.Sprintf("%s must be at least %s characters long", fe.Field(), fe.Param())
+		}
+		return fmt.Sprintf("%s must be at least %s", fe.Field(), fe.Param())
+	case "max":
+		if isString {
+			return fmt.Sprintf("%s must be at most %s characters long", fe.Field(), fe.Param())
+		}
+		return fmt.Sprintf("%s must be at most %s", fe.Field(), fe.Param())
+	case "productcode":
+		return fmt.Sprintf("%s may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit", fe.Field())
+	}
+	return fmt.Sprintf("%s failed the %s rule", fe.Field(), fe.Tag())
+}
+
+// fieldErrors converts validator errors to FieldErrors; ok is false for
+// any other error (e.g. malformed JSON).
+func fieldErrors(err error) ([]FieldError, bool) {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return nil, false
+	}
+	out := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		out = append(out, FieldError{Field: fe.Field(), Rule: fe.Tag(), Param: fe.Param(), Message: fieldMessage(fe)})
+	}
+	return out, true
+}
+
+// validateProduct checks a full product against the productPayload rules.
+func validateProduct(code string, price uint) []FieldError {
+	fields, _ := fieldErrors(binding.Validator.ValidateStruct(productPayload{Code: code, Price: price}))
+	return fields
+}
+
+// validateProductChanges checks the fields set in changes against the
+// productPayload rules.
+func validateProductChanges(changes ProductChanges) []FieldError {
+	var payload productPayload
+	set := map[string]bool{}
+	if changes.Code != nil {
+		payload.Code = *changes.Code
+		set["code"] = true
+	}
+	if changes.Price != nil {
+		payload.Price = *changes.Price
+		set["price"] = true
+	}
+	var fields []FieldError
+	for _, fe := range validateProduct(payload.Code, payload.Price) {
+		if set[fe.Field] {
+			fields = append(fields, fe)
+		}
+	}
+	return fields
+}
+
+// respondBindError responds to a failed ShouldBindJSON: rule violations
+// become VALIDATION_FAILED with the list of FieldErrors, anything else
+// (malformed JSON, wrong types) INVALID_REQUEST.
+func respondBindError(c *gin.Context, err error) {
+	if fields, ok := fieldErrors(err); ok {
+		respondErrorCode(c, CodeValidationFailed, fields)
+		return
+	}
+	respondErrorCode(c, CodeInvalidRequest, err.Error())
+}
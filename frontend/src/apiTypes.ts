@@ -9,6 +9,7 @@ export interface APIError {
 export interface ErrorEnvelope {
   success: false;
   status: number;
+  request_id: string;
   error: APIError;
 }
 
@@ -26,6 +27,7 @@ export interface Product {
   CreatedAt: string;
   UpdatedAt: string;
   DeletedAt: string | null;
+  Version: number;
 }
 
 export type ProductListResponse = SuccessEnvelope<Product[]>;
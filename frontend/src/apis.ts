@@ -89,11 +89,12 @@ export const deleteProductById = async (id: number): Promise<{ message: string }
 export const deleteProductByIdPromise = (id: number) =>
   fetch(`http://localhost:8080/product/${id}`, { method: "DELETE" }).then((res) => handleResponse<{ message: string }>(res));
 
+// Partial updates use PATCH with a JSON Merge Patch so omitted fields are left unchanged.
 export const updateProductById = async (id: number, productData: { code?: string; price?: number }): Promise<Product> => {
   const response = await fetch(`http://localhost:8080/product/${id}`, {
-    method: "PUT",
+    method: "PATCH",
     headers: {
-      "Content-Type": "application/json",
+      "Content-Type": "application/merge-patch+json",
     },
     body: JSON.stringify(productData),
   });
@@ -105,9 +106,9 @@ export const updateProductById = async (id: number, productData: { code?: string
 
 export const updateProductByIdPromise = (id: number, productData: { code?: string; price?: number }) =>
   fetch(`http://localhost:8080/product/${id}`, {
-    method: "PUT",
+    method: "PATCH",
     headers: {
-      "Content-Type": "application/json",
+      "Content-Type": "application/merge-patch+json",
     },
     body: JSON.stringify(productData),
   }).then((res) => handleResponse<Product>(res));
@@ -7,6 +7,7 @@ import {
   deleteProductById,
 } from "../apis";
 import type { Product } from "../apiTypes";
+import { validateProduct } from "../validationRules";
 import { Button } from "./ui/button";
 import { Input } from "./ui/input";
 import { Card, CardHeader, CardTitle, CardContent } from "./ui/card";
@@ -30,11 +31,14 @@ export default function CRUDExamples() {
   const onCreate = async (e: React.FormEvent) => {
     e.preventDefault();
     setMessage(null);
+    const payload = { code: createState.code, price: Number(createState.price) };
+    const fieldErrors = validateProduct(payload);
+    if (fieldErrors.length > 0) {
+      setMessage(fieldErrors.map((fe) => fe.message).join("; "));
+      return;
+    }
     try {
-      const p = await createProduct({
-        code: createState.code,
-        price: Number(createState.price),
-      });
+      const p = await createProduct(payload);
       setMessage(`Created id=${p.ID} code=${p.Code} price=${p.Price}`);
       setCreateState({ code: "", price: "" });
     } catch (err: any) {
@@ -76,6 +80,8 @@ export default function CRUDExamples() {
       const payload: any = {};
       if (updateState.code) payload.code = updateState.code;
       if (updateState.price) payload.price = Number(updateState.price);
+      const fieldErrors = validateProduct(payload, true);
+      if (fieldErrors.length > 0) throw new Error(fieldErrors.map((fe) => fe.message).join("; "));
       const p = await updateProductById(id, payload);
       setMessage(`Updated id=${p.ID} code=${p.Code} price=${p.Price}`);
       setUpdateState({ id: "", code: "", price: "" });
@@ -7,6 +7,25 @@ export const CodeProductsNotFound = "PRODUCTS_NOT_FOUND";
 export const CodeInvalidRequest = "INVALID_REQUEST";
 export const CodeInvalidID = "INVALID_ID";
 export const CodePerPageTooLarge = "PER_PAGE_TOO_LARGE";
+export const CodeInvalidFilter = "INVALID_FILTER";
+export const CodeInvalidCursor = "INVALID_CURSOR";
+export const CodeReadOnlyField = "READ_ONLY_FIELD";
+export const CodePatchFailed = "PATCH_FAILED";
+export const CodeUnsupportedMedia = "UNSUPPORTED_MEDIA_TYPE";
+export const CodePreconditionFailed = "PRECONDITION_FAILED";
+export const CodePreconditionRequired = "PRECONDITION_REQUIRED";
+export const CodeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED";
+export const CodeIdempotencyInProgress = "IDEMPOTENCY_REQUEST_IN_PROGRESS";
+export const CodeProductCodeConflict = "PRODUCT_CODE_CONFLICT";
+export const CodeAdminRequired = "ADMIN_REQUIRED";
+export const CodeBulkTooLarge = "BULK_TOO_LARGE";
+export const CodeBulkRolledBack = "BULK_ROLLED_BACK";
+export const CodeNotAcceptable = "NOT_ACCEPTABLE";
+export const CodeValidationFailed = "VALIDATION_FAILED";
+export const CodeBulkOperationNotApplied = "BULK_OPERATION_NOT_APPLIED";
+export const CodeShuttingDown = "SHUTTING_DOWN";
+export const CodeNotReady = "NOT_READY";
+export const CodeImportIncomplete = "IMPORT_INCOMPLETE";
 
 export const ErrorMessages: Record<string, string> = {
   [CodeInternalError]: "internal server error",
@@ -15,8 +34,109 @@ export const ErrorMessages: Record<string, string> = {
   [CodeInvalidRequest]: "invalid request",
   [CodeInvalidID]: "invalid product id",
   [CodePerPageTooLarge]: "per_page exceeds maximum allowed",
+  [CodeInvalidFilter]: "invalid filter or sort parameter",
+  [CodeInvalidCursor]: "invalid pagination cursor",
+  [CodeReadOnlyField]: "field is read-only",
+  [CodePatchFailed]: "patch could not be applied",
+  [CodeUnsupportedMedia]: "unsupported content type",
+  [CodePreconditionFailed]: "product was modified by another request",
+  [CodePreconditionRequired]: "If-Match header is required",
+  [CodeIdempotencyKeyReused]: "Idempotency-Key was already used for a different request",
+  [CodeIdempotencyInProgress]: "a request with this Idempotency-Key is still being processed",
+  [CodeProductCodeConflict]: "another product already uses this code",
+  [CodeAdminRequired]: "admin privileges required",
+  [CodeBulkTooLarge]: "too many operations in one batch",
+  [CodeBulkRolledBack]: "batch rolled back because an operation failed",
+  [CodeNotAcceptable]: "none of the accepted media types can be produced",
+  [CodeValidationFailed]: "one or more fields are invalid",
+  [CodeBulkOperationNotApplied]: "operation not applied because another operation in the batch failed",
+  [CodeShuttingDown]: "server is shutting down",
+  [CodeNotReady]: "a required dependency is unavailable",
+  [CodeImportIncomplete]: "import stopped partway; earlier rows were saved",
 };
 
+export const RetryableErrorCodes: ReadonlySet<string> = new Set([
+  CodeInternalError,
+  CodeIdempotencyInProgress,
+  CodeShuttingDown,
+  CodeNotReady,
+  CodeImportIncomplete,
+]);
+
+export const errorDocURL = (code: string): string => `https://github.com/tanjunior/may/blob/main/docs/errors.md#${code.toLowerCase()}`;
+
+export const LocalizedErrorMessages: Record<string, Record<string, string>> = {
+  "en": ErrorMessages,
+  "es": {
+    [CodeInternalError]: "error interno del servidor",
+    [CodeProductNotFound]: "producto no encontrado",
+    [CodeProductsNotFound]: "productos no encontrados",
+    [CodeInvalidRequest]: "solicitud no válida",
+    [CodeInvalidID]: "id de producto no válido",
+    [CodePerPageTooLarge]: "per_page supera el máximo permitido",
+    [CodeInvalidFilter]: "parámetro de filtro u orden no válido",
+    [CodeInvalidCursor]: "cursor de paginación no válido",
+    [CodeReadOnlyField]: "el campo es de solo lectura",
+    [CodePatchFailed]: "no se pudo aplicar el patch",
+    [CodeUnsupportedMedia]: "tipo de contenido no admitido",
+    [CodePreconditionFailed]: "otra solicitud modificó el producto",
+    [CodePreconditionRequired]: "se requiere la cabecera If-Match",
+    [CodeIdempotencyKeyReused]: "la Idempotency-Key ya se usó para otra solicitud",
+    [CodeIdempotencyInProgress]: "todavía se está procesando una solicitud con esta Idempotency-Key",
+    [CodeProductCodeConflict]: "otro producto ya usa este código",
+    [CodeAdminRequired]: "se requieren privilegios de administrador",
+    [CodeBulkTooLarge]: "demasiadas operaciones en un solo lote",
+    [CodeBulkRolledBack]: "el lote se revirtió porque falló una operación",
+    [CodeNotAcceptable]: "no se puede generar ninguno de los tipos de medio aceptados",
+    [CodeValidationFailed]: "uno o más campos no son válidos",
+    [CodeBulkOperationNotApplied]: "la operación no se aplicó porque falló otra operación del lote",
+    [CodeShuttingDown]: "el servidor se está apagando",
+    [CodeNotReady]: "una dependencia necesaria no está disponible",
+    [CodeImportIncomplete]: "la importación se detuvo a mitad; las filas anteriores se guardaron",
+  },
+  "th": {
+    [CodeInternalError]: "เกิดข้อผิดพลาดภายในเซิร์ฟเวอร์",
+    [CodeProductNotFound]: "ไม่พบสินค้า",
+    [CodeProductsNotFound]: "ไม่พบรายการสินค้า",
+    [CodeInvalidRequest]: "คำขอไม่ถูกต้อง",
+    [CodeInvalidID]: "รหัสสินค้าไม่ถูกต้อง",
+    [CodePerPageTooLarge]: "per_page เกินจำนวนสูงสุดที่อนุญาต",
+    [CodeInvalidFilter]: "พารามิเตอร์ตัวกรองหรือการเรียงลำดับไม่ถูกต้อง",
+    [CodeInvalidCursor]: "cursor สำหรับแบ่งหน้าไม่ถูกต้อง",
+    [CodeReadOnlyField]: "ฟิลด์นี้แก้ไขไม่ได้",
+    [CodePatchFailed]: "ไม่สามารถใช้ patch ได้",
+    [CodeUnsupportedMedia]: "ไม่รองรับประเภทเนื้อหานี้",
+    [CodePreconditionFailed]: "สินค้าถูกแก้ไขโดยคำขออื่นแล้ว",
+    [CodePreconditionRequired]: "ต้องระบุ header If-Match",
+    [CodeIdempotencyKeyReused]: "Idempotency-Key นี้ถูกใช้กับคำขออื่นไปแล้ว",
+    [CodeIdempotencyInProgress]: "คำขอที่ใช้ Idempotency-Key นี้ยังดำเนินการอยู่",
+    [CodeProductCodeConflict]: "รหัสนี้ถูกใช้โดยสินค้าอื่นแล้ว",
+    [CodeAdminRequired]: "ต้องมีสิทธิ์ผู้ดูแลระบบ",
+    [CodeBulkTooLarge]: "จำนวนคำสั่งในชุดเดียวมากเกินไป",
+    [CodeBulkRolledBack]: "ยกเลิกทั้งชุดเนื่องจากมีคำสั่งที่ล้มเหลว",
+    [CodeNotAcceptable]: "ไม่สามารถสร้างข้อมูลในรูปแบบที่ยอมรับได้",
+    [CodeValidationFailed]: "มีฟิลด์ที่ไม่ถูกต้องอย่างน้อยหนึ่งฟิลด์",
+    [CodeBulkOperationNotApplied]: "ไม่ได้ดำเนินการคำสั่งนี้เนื่องจากมีคำสั่งอื่นในชุดที่ล้มเหลว",
+    [CodeShuttingDown]: "เซิร์ฟเวอร์กำลังปิดตัว",
+    [CodeNotReady]: "บริการที่จำเป็นบางรายการไม่พร้อมใช้งาน",
+    [CodeImportIncomplete]: "การนำเข้าหยุดกลางคัน แถวก่อนหน้าถูกบันทึกแล้ว",
+  },
+};
+
+export function errorMessage(
+  code: string,
+  languages: readonly string[] = typeof navigator !== "undefined" ? navigator.languages : [],
+): string {
+  for (const language of languages) {
+    const tag = language.toLowerCase();
+    for (const candidate of [tag, tag.split("-")[0]]) {
+      const msg = LocalizedErrorMessages[candidate]?.[code];
+      if (msg) return msg;
+    }
+  }
+  return ErrorMessages[code] ?? code;
+}
+
 export default {
   CodeInternalError,
   CodeProductNotFound,
@@ -24,5 +144,27 @@ export default {
   CodeInvalidRequest,
   CodeInvalidID,
   CodePerPageTooLarge,
+  CodeInvalidFilter,
+  CodeInvalidCursor,
+  CodeReadOnlyField,
+  CodePatchFailed,
+  CodeUnsupportedMedia,
+  CodePreconditionFailed,
+  CodePreconditionRequired,
+  CodeIdempotencyKeyReused,
+  CodeIdempotencyInProgress,
+  CodeProductCodeConflict,
+  CodeAdminRequired,
+  CodeBulkTooLarge,
+  CodeBulkRolledBack,
+  CodeNotAcceptable,
+  CodeValidationFailed,
+  CodeBulkOperationNotApplied,
+  CodeShuttingDown,
+  CodeNotReady,
+  CodeImportIncomplete,
   ErrorMessages,
+  RetryableErrorCodes,
+  LocalizedErrorMessages,
+  errorMessage,
 };
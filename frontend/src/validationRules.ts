@@ -0,0 +1,73 @@
+// GENERATED FROM the productPayload binding tags in backend/validation.go
+// Keep in sync with backend; used by forms to validate products before submitting.
+
+export interface FieldRule {
+  rule: string;
+  param?: string;
+}
+
+export interface FieldError {
+  field: string;
+  rule: string;
+  param?: string;
+  message: string;
+}
+
+export const ProductCodePattern = new RegExp("^[A-Za-z0-9][A-Za-z0-9._-]*$");
+
+export const ProductRules: Record<string, { type: "string" | "number"; rules: FieldRule[] }> = {
+  code: {
+    type: "string",
+    rules: [
+      { rule: "required" },
+      { rule: "max", param: "64" },
+      { rule: "productcode" },
+    ],
+  },
+  price: {
+    type: "number",
+    rules: [
+      { rule: "required" },
+      { rule: "max", param: "1000000000" },
+    ],
+  },
+};
+
+export function validateProduct(
+  values: Record<string, string | number | undefined>,
+  partial = false,
+): FieldError[] {
+  const errors: FieldError[] = [];
+  for (const [field, { type, rules }] of Object.entries(ProductRules)) {
+    const value = values[field];
+    if (partial && value === undefined) continue;
+    const isString = type === "string";
+    const size = isString ? String(value ?? "").length : Number(value ?? 0);
+    for (const { rule, param } of rules) {
+      let message: string | null = null;
+      switch (rule) {
+        case "required":
+          if (isString ? size === 0 : !size) message = `${field} is required`;
+          break;
+        case "min":
+          if (size < Number(param))
+            message = isString
+              ? `${field} must be at least ${param} characters long`
+              : `${field} must be at least ${param}`;
+          break;
+        case "max":
+          if (size > Number(param))
+            message = isString
+              ? `${field} must be at most ${param} characters long`
+              : `${field} must be at most ${param}`;
+          break;
+        case "productcode":
+          if (!ProductCodePattern.test(String(value ?? "")))
+            message = `${field} may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit`;
+          break;
+      }
+      if (message) errors.push({ field, rule, ...(param ? { param } : {}), message });
+    }
+  }
+  return errors;
+}